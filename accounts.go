@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+)
+
+// AccountStore is the backend used to verify account logins.
+// The in-memory store below is the default; anything that can answer
+// these three questions (a database, an external services daemon) can be swapped in.
+// Every method returns the account name as the backend spells it.
+type AccountStore interface {
+	CheckPassword(account string, password string) (name string, ok bool)
+	ScramCredentials(account string) (name string, creds scramCredentials, ok bool)
+	CertFPAccount(fingerprint string) (name string, ok bool)
+}
+
+const scramIterations = 4096
+
+type scramCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+func deriveScramKeys(password string, salt []byte, iterations int) (storedKey []byte, serverKey []byte) {
+	// RFC 5802 section 3, using SHA-256 (RFC 7677).
+	salted, _ := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	clientKey := hmacSHA256(salted, "Client Key")
+	stored := sha256.Sum256(clientKey)
+	return stored[:], hmacSHA256(salted, "Server Key")
+}
+
+func newScramCredentials(password string) scramCredentials {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	storedKey, serverKey := deriveScramKeys(password, salt, scramIterations)
+	return scramCredentials{salt, scramIterations, storedKey, serverKey}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+type memoryAccount struct {
+	Name   string
+	Creds  scramCredentials
+	CertFP []string // Hex SHA-256 fingerprints allowed to log in with EXTERNAL.
+}
+
+type memoryAccounts struct {
+	accounts map[string]*memoryAccount // Keyed by lowercase account name.
+}
+
+func newMemoryAccounts() *memoryAccounts {
+	return &memoryAccounts{make(map[string]*memoryAccount)}
+}
+
+func (store *memoryAccounts) addAccount(name string, password string, certfp ...string) {
+	// Only the SCRAM keys are kept, never the password itself.
+	store.setAccount(name, newScramCredentials(password), certfp)
+}
+
+func (store *memoryAccounts) setAccount(name string, creds scramCredentials, certfp []string) {
+	for i := range certfp {
+		certfp[i] = normalizeCertFP(certfp[i])
+	}
+	store.accounts[strings.ToLower(name)] = &memoryAccount{name, creds, certfp}
+}
+
+func (store *memoryAccounts) CheckPassword(account string, password string) (string, bool) {
+	a, ok := store.accounts[strings.ToLower(account)]
+	if !ok {
+		return "", false
+	}
+	storedKey, _ := deriveScramKeys(password, a.Creds.Salt, a.Creds.Iterations)
+	return a.Name, hmac.Equal(storedKey, a.Creds.StoredKey)
+}
+
+func (store *memoryAccounts) ScramCredentials(account string) (string, scramCredentials, bool) {
+	if a, ok := store.accounts[strings.ToLower(account)]; ok {
+		return a.Name, a.Creds, true
+	}
+	return "", scramCredentials{}, false
+}
+
+func (store *memoryAccounts) CertFPAccount(fingerprint string) (string, bool) {
+	for _, a := range store.accounts {
+		for _, fp := range a.CertFP {
+			if fp == normalizeCertFP(fingerprint) {
+				return a.Name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,39 @@
+package main
+
+import "strings"
+
+func init() {
+	registerCap(capability{Name: "away-notify"})
+}
+
+func IRC_AWAY(msg *ircMessage) (string, string) {
+	// AWAY [<message>]
+	// No message, or an empty one, marks the user as back.
+	user := msg.User
+	if len(msg.Params) == 0 || msg.Params[0] == "" {
+		if user.AWAY {
+			user.AWAY, user.AwayMsg = false, ""
+			user.Modes = strings.ReplaceAll(user.Modes, "a", "")
+			user.notifyAway()
+		}
+		return RPL_UNAWAY, ":You are no longer marked as being away"
+	}
+
+	reason := truncate(msg.Params[0], msg.Server.Config.Limits.AwayLen)
+	if !user.AWAY || user.AwayMsg != reason { // Peers only hear about changes.
+		if !user.AWAY {
+			user.Modes += "a"
+		}
+		user.AWAY, user.AwayMsg = true, reason
+		user.notifyAway()
+	}
+	return RPL_NOWAWAY, ":You have been marked as being away"
+}
+
+func (user *ircUser) notifyAway() {
+	if user.AWAY {
+		user.notifyChange("away-notify", "AWAY", user.AwayMsg)
+	} else {
+		user.notifyChange("away-notify", "AWAY")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Away(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	bob.Caps = map[string]bool{"away-notify": true}
+	send(alice, "JOIN #test")
+	send(bob, "JOIN #test")
+	send(carol, "JOIN #test")
+	drain(alice)
+	drain(bob)
+	drain(carol)
+
+	send(alice, "AWAY :lunch")
+	send(alice, "MODE alice -a")
+	send(alice, "MODE alice")
+	lines := drain(alice)
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " 306 alice :You have been marked as being away") ||
+		!strings.HasSuffix(lines[1], " 221 alice +a") {
+		t.Errorf("going away gave %q", lines)
+	}
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 AWAY lunch" {
+		t.Errorf("bob got %q", lines)
+	}
+	if lines := drain(carol); len(lines) != 0 {
+		t.Errorf("carol didn't ask for away-notify but got %q", lines)
+	}
+
+	send(carol, "PRIVMSG alice :hi")
+	send(carol, "NOTICE alice :hi")
+	if lines := drain(carol); len(lines) != 1 || !strings.HasSuffix(lines[0], " 301 carol alice :lunch") {
+		t.Errorf("messaging an away user gave %q", lines)
+	}
+	send(carol, "USERHOST alice")
+	if lines := drain(carol); len(lines) != 1 || !strings.Contains(lines[0], "alice=-alice@127.0.0.1") {
+		t.Errorf("USERHOST gave %q", lines)
+	}
+
+	send(alice, "PART #test")
+	drain(bob)
+	send(alice, "JOIN #test")
+	if lines := drain(bob); len(lines) != 2 || lines[1] != ":alice!~alice@127.0.0.1 AWAY lunch" {
+		t.Errorf("rejoining gave %q", lines)
+	}
+
+	drain(alice)
+	send(alice, "AWAY")
+	if lines := drain(alice); !strings.HasSuffix(lines[len(lines)-1], " 305 alice :You are no longer marked as being away") || alice.Modes != "" {
+		t.Errorf("coming back gave %q, modes %q", lines, alice.Modes)
+	}
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 AWAY" {
+		t.Errorf("bob got %q", lines)
+	}
+
+	// Repeats aren't changes.
+	send(alice, "AWAY")
+	send(carol, "AWAY :lunch")
+	send(carol, "AWAY :lunch")
+	drain(alice)
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":carol!~carol@127.0.0.1 AWAY lunch" {
+		t.Errorf("repeated AWAYs sent bob %q", lines)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type capability struct {
+	Name      string
+	Value     func(user *ircUser) string // Optional, only shown to CAP LS 302 clients.
+	Available func(server *Server) bool  // Optional, nil means always offered.
+}
+
+// Every feature registers the capabilities it implements from an init() in its own file.
+var capabilities = make(map[string]*capability)
+
+func registerCap(c capability) {
+	capabilities[c.Name] = &c
+}
+
+func init() {
+	registerCap(capability{Name: "cap-notify"})
+}
+
+func (user *ircUser) hasCap(name string) bool {
+	return user.Caps[name]
+}
+
+func (user *ircUser) capNick() string {
+	// Clients that haven't picked a nick yet are addressed as "*".
+	if user.Nick == "AUTH" {
+		return "*"
+	}
+	return user.Nick
+}
+
+func (server *Server) capList(user *ircUser, names []string) []string {
+	// Returns the cap tokens to send to user, values included for 302 clients.
+	sort.Strings(names)
+	tokens := make([]string, 0, len(names))
+	for _, name := range names {
+		token := name
+		if c := capabilities[name]; user.CapVersion >= 302 && c != nil && c.Value != nil {
+			if value := c.Value(user); value != "" {
+				token += "=" + value
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (user *ircUser) sendCapList(subcommand string, tokens []string) {
+	// Splits long replies over several lines, using the 302 "*" continuation marker.
+	lines := []string{strings.Join(tokens, " ")} // Pre-302 clients get it all on one line.
+	if user.CapVersion >= 302 && len(tokens) > 0 {
+		lines = packLines(":"+user.Server.Host+" CAP "+user.capNick()+" "+subcommand+" * :", " ", tokens)
+	}
+
+	for i, l := range lines {
+		if i < len(lines)-1 {
+			user.sendMessage(user.Server.Host, "CAP", user.capNick(), subcommand, "*", l)
+		} else {
+			user.sendMessage(user.Server.Host, "CAP", user.capNick(), subcommand, l)
+		}
+	}
+}
+
+func (server *Server) updateCaps() {
+	// Recomputes which caps are offered, and tells cap-notify clients what changed.
+	var added, removed []string
+	for name, c := range capabilities {
+		available := c.Available == nil || c.Available(server)
+		if available && !server.Caps[name] {
+			added = append(added, name)
+			server.Caps[name] = true
+		} else if !available && server.Caps[name] {
+			removed = append(removed, name)
+			delete(server.Caps, name)
+		}
+	}
+	for name := range server.Caps {
+		if capabilities[name] == nil { // Unregistered since the last update.
+			removed = append(removed, name)
+			delete(server.Caps, name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	users := make([]*ircUser, 0, len(server.Clients)+len(server.Unregistered))
+	for _, u := range server.Clients {
+		users = append(users, u)
+	}
+	for _, u := range server.Unregistered {
+		users = append(users, u)
+	}
+	for _, u := range users {
+		for _, name := range removed {
+			delete(u.Caps, name)
+		}
+		if !u.hasCap("cap-notify") {
+			continue
+		}
+		if len(added) > 0 {
+			u.sendCapList("NEW", server.capList(u, added))
+		}
+		if len(removed) > 0 {
+			sort.Strings(removed)
+			u.sendCapList("DEL", removed)
+		}
+	}
+}
+
+func (user *ircUser) requestCaps(request string) bool {
+	// Applies a CAP REQ atomically: either every change is valid and applied, or none are.
+	changes := strings.Fields(request)
+	if len(changes) == 0 {
+		return false
+	}
+	for _, change := range changes {
+		name := strings.TrimPrefix(change, "-")
+		if !user.Server.Caps[name] {
+			return false
+		}
+		// 302 clients get cap-notify implicitly, and can't turn it off.
+		if change == "-cap-notify" && user.CapVersion >= 302 {
+			return false
+		}
+	}
+
+	if user.Caps == nil {
+		user.Caps = make(map[string]bool)
+	}
+	for _, change := range changes {
+		if strings.HasPrefix(change, "-") {
+			delete(user.Caps, change[1:])
+		} else {
+			user.Caps[change] = true
+		}
+	}
+	return true
+}
+
+func IRC_CAP(msg *ircMessage) (string, string) {
+	// CAP LS [version] / CAP LIST / CAP REQ :<caps> / CAP END
+	user := msg.User
+	subcommand := strings.ToUpper(msg.Params[0])
+
+	switch subcommand {
+	case "LS":
+		// Hold registration until the client is done negotiating.
+		if user.State == statePreRegistration {
+			user.State = stateCapNegotiating
+		}
+		if len(msg.Params) > 1 {
+			if version, err := strconv.Atoi(msg.Params[1]); err == nil && version > user.CapVersion {
+				user.CapVersion = version
+			}
+		}
+		if user.CapVersion >= 302 {
+			user.requestCaps("cap-notify")
+		}
+		names := make([]string, 0, len(msg.Server.Caps))
+		for name := range msg.Server.Caps {
+			names = append(names, name)
+		}
+		user.sendCapList("LS", msg.Server.capList(user, names))
+
+	case "LIST":
+		names := make([]string, 0, len(user.Caps))
+		for name := range user.Caps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		user.sendCapList("LIST", names)
+
+	case "REQ":
+		if user.State == statePreRegistration {
+			user.State = stateCapNegotiating
+		}
+		request := ""
+		if len(msg.Params) > 1 {
+			request = msg.Params[1]
+		}
+		if user.requestCaps(request) {
+			user.sendMessage(msg.Server.Host, "CAP", user.capNick(), "ACK", request)
+		} else {
+			user.sendMessage(msg.Server.Host, "CAP", user.capNick(), "NAK", request)
+		}
+
+	case "END":
+		if user.State == stateCapNegotiating {
+			user.State = statePreRegistration
+			user.tryRegister()
+		}
+
+	default:
+		return ERR_INVALIDCAPSUBCOMMAND, msg.Params[0] + " :Invalid CAP command"
+	}
+	return "", ""
+}
@@ -0,0 +1,47 @@
+package main
+
+import "strings"
+
+// Casemappings we can advertise in CASEMAPPING, by name.
+var caseMappings = map[string]func(string) string{
+	"ascii":   foldASCII,
+	"rfc1459": foldRFC1459,
+	"rfc7613": foldRFC7613,
+}
+
+func (server *Server) casefold(name string) string {
+	// Folds nicks and channel names the same way, so both compare consistently.
+	return caseMappings[server.Config.Server.caseMapping()](name)
+}
+
+func foldASCII(name string) string {
+	// Only A-Z, everything else is left alone.
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name)
+}
+
+func foldRFC1459(name string) string {
+	// ascii, plus []\^ are the uppercase of {}|~, for Scandinavian reasons.
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= '^' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, name)
+}
+
+func foldRFC7613(name string) string {
+	// Full Unicode lowercasing, with fullwidth forms mapped to their ASCII
+	// equivalents. Nicks are ASCII anyway, this matters for channel names.
+	// PRECIS' normalization step needs golang.org/x/text, so it's left out.
+	return strings.Map(func(r rune) rune {
+		if r >= '！' && r <= '～' { // U+FF01 to U+FF5E
+			r = r - '！' + '!'
+		}
+		return r
+	}, strings.ToLower(name))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Casefold(t *testing.T) {
+	tests := []struct {
+		fold     func(string) string
+		in, want string
+	}{
+		{foldASCII, "Nick[A]^", "nick[a]^"},
+		{foldRFC1459, "Nick[A]\\^", "nick{a}|~"},
+		{foldRFC7613, "#ＣＨＡＮ", "#chan"},
+		{foldRFC7613, "#Ünïcode", "#ünïcode"},
+	}
+	for _, test := range tests {
+		if got := test.fold(test.in); got != test.want {
+			t.Errorf("folding %q gave %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func Test_CaseMapping_Nicks(t *testing.T) {
+	server := mock_user().Server
+	alice := mock_client(server, "[alice]")
+	bob := mock_client(server, "bob")
+
+	send(bob, "NICK {Alice}")
+	if lines := drain(bob); len(lines) != 1 || !strings.Contains(lines[0], " 433 bob [alice] ") {
+		t.Errorf("NICK {Alice} gave %q", lines)
+	}
+	if user, ok := server.findUser("{ALICE}"); !ok || user != alice {
+		t.Error("findUser didn't fold the nick")
+	}
+
+	send(alice, "NICK {Alice}")
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":[alice]!~[alice]@127.0.0.1 NICK {Alice}" {
+		t.Errorf("changing case of own nick gave %q", lines)
+	}
+	if len(server.Clients) != 2 || len(server.Nicks) != 2 || server.Clients["{alice}"] != alice {
+		t.Errorf("nick index is %v", server.Nicks)
+	}
+
+	send(bob, "JOIN #Chan[1]")
+	send(alice, "JOIN #chan{1}")
+	if channel, ok := server.findChannel("#CHAN[1]"); !ok || len(channel.Members) != 2 {
+		t.Error("channel names weren't folded")
+	}
+}
+
+func Test_CaseMapping_Config(t *testing.T) {
+	server := mock_user().Server
+	if !strings.Contains(strings.Join(server.isupport(), " "), " CASEMAPPING=rfc1459 ") {
+		t.Error("CASEMAPPING isn't advertised")
+	}
+	server.Config.Server.CaseMapping = "ascii"
+	if server.casefold("[A]") != "[a]" {
+		t.Error("ascii casemapping folded brackets")
+	}
+}
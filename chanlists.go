@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type listEntry struct {
+	Mask  string
+	SetBy string // nick!user@host of whoever added it.
+	SetAt time.Time
+}
+
+// Extended ban types, written $<type>:<argument>.
+// a - logged in to a matching account, "$a" alone for anyone logged in;
+// r - realname matches the mask;
+// m - mute only, the argument is any other mask. Matching users can join but not speak.
+const extbanTypes = "amr"
+
+// How each list is shown.
+var listReplies = map[byte]struct{ entry, end, name string }{
+	'b': {RPL_BANLIST, RPL_ENDOFBANLIST, "ban"},
+	'e': {RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST, "exception"},
+	'I': {RPL_INVITELIST, RPL_ENDOFINVITELIST, "invite exception"},
+}
+
+func normalizeMask(mask string) (string, bool) {
+	// Fills in a partial nick!user@host mask, and checks extbans are ones we know.
+	if strings.HasPrefix(mask, "$") {
+		kind, arg, hasArg := strings.Cut(mask[1:], ":")
+		if len(kind) != 1 || !strings.Contains(extbanTypes, kind) {
+			return "", false
+		}
+		switch {
+		case kind == "m" && hasArg:
+			if arg, ok := normalizeMask(arg); ok {
+				return "$m:" + arg, true
+			}
+			return "", false
+		case kind == "a" && !hasArg:
+			return mask, true
+		}
+		if !hasArg || arg == "" {
+			return "", false
+		}
+		return mask, true
+	}
+	if mask == "" || strings.ContainsAny(mask, " ,") {
+		return "", false
+	}
+	nick, rest, hasUser := strings.Cut(mask, "!")
+	if !hasUser {
+		if strings.Contains(mask, "@") { // user@host
+			return "*!" + mask, true
+		}
+		return mask + "!*@*", true // Just a nick.
+	}
+	if nick == "" {
+		nick = "*"
+	}
+	if !strings.Contains(rest, "@") {
+		rest += "@*"
+	}
+	return nick + "!" + rest, true
+}
+
+func (user *ircUser) matchesEntry(mask string) bool {
+	if !strings.HasPrefix(mask, "$") {
+		return matchMask(user.Server.casefold(mask), user.Server.casefold(user.Host))
+	}
+	kind, arg, _ := strings.Cut(mask[1:], ":")
+	switch kind {
+	case "a":
+		return user.Account != "" && (arg == "" || user.Server.casefold(arg) == user.Server.casefold(user.Account))
+	case "r":
+		return matchMask(arg, user.Realname)
+	case "m":
+		return user.matchesEntry(arg)
+	}
+	return false
+}
+
+func (channel *Channel) listMatches(mode byte, user *ircUser, mute bool) bool {
+	// Whether an entry on the list matches user. Mute-only entries are
+	// skipped unless mute is set, and only ever count then.
+	for _, entry := range channel.Lists[mode] {
+		if strings.HasPrefix(entry.Mask, "$m:") == mute && user.matchesEntry(entry.Mask) {
+			return true
+		}
+	}
+	return false
+}
+
+func (channel *Channel) isBanned(user *ircUser) bool {
+	return channel.listMatches('b', user, false) && !channel.listMatches('e', user, false)
+}
+
+func (channel *Channel) isMuted(user *ircUser) bool {
+	// Banned members can't speak either, in case they got in before the ban.
+	muted := channel.listMatches('b', user, true) || channel.listMatches('b', user, false)
+	return muted && !channel.listMatches('e', user, false)
+}
+
+func (channel *Channel) findEntry(mode byte, mask string) int {
+	for i, entry := range channel.Lists[mode] {
+		if channel.Server.casefold(entry.Mask) == channel.Server.casefold(mask) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (channel *Channel) listSize() (size int) {
+	// MAXLIST counts every list together.
+	for _, entries := range channel.Lists {
+		size += len(entries)
+	}
+	return
+}
+
+func (channel *Channel) setListEntry(user *ircUser, change modeChange) {
+	entries := channel.Lists[change.Mode]
+	if !change.Adding {
+		i := channel.findEntry(change.Mode, change.Param)
+		channel.Lists[change.Mode] = append(entries[:i], entries[i+1:]...)
+		return
+	}
+	channel.Lists[change.Mode] = append(entries, listEntry{change.Param, user.Host, time.Now()})
+}
+
+func (user *ircUser) sendList(channel *Channel, mode byte) {
+	replies := listReplies[mode]
+	for _, entry := range channel.Lists[mode] {
+		user.sendNumeric(replies.entry, channel.Name, entry.Mask, entry.SetBy, strconv.FormatInt(entry.SetAt.Unix(), 10))
+	}
+	user.sendNumeric(replies.end, channel.Name, ":End of channel "+replies.name+" list")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Normalize_Mask(t *testing.T) {
+	for mask, want := range map[string]string{
+		"nick":            "nick!*@*",
+		"user@host":       "*!user@host",
+		"!user":           "*!user@*",
+		"n!u@h":           "n!u@h",
+		"$a":              "$a",
+		"$a:Account":      "$a:Account",
+		"$r:*bot*":        "$r:*bot*",
+		"$m:spammer":      "$m:spammer!*@*",
+		"$m:$a:troll":     "$m:$a:troll",
+		"$x:what":         "",
+		"$r":              "",
+		"has space!u@h":   "",
+		"$m:$a:troll!u@h": "$m:$a:troll!u@h",
+	} {
+		got, ok := normalizeMask(mask)
+		if got != want || ok != (want != "") {
+			t.Errorf("normalizeMask(%q) = %q, %t", mask, got, ok)
+		}
+	}
+}
+
+func Test_Channel_Lists(t *testing.T) {
+	server := mock_user().Server
+	server.Config.Limits.MaxList = 2
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	bob.Account, bob.Realname = "bobby", "Robert"
+	send(alice, "JOIN #test")
+	drain(alice)
+
+	send(alice, "MODE #test +bb-b bob BOB!*@* $r:Rob*")
+	send(alice, "MODE #test +b")
+	lines := drain(alice)
+	if len(lines) != 3 || lines[0] != ":alice!~alice@127.0.0.1 MODE #test +b BOB!*@*" ||
+		!strings.Contains(lines[1], " 367 alice #test BOB!*@* alice!~alice@127.0.0.1 ") ||
+		!strings.HasSuffix(lines[2], " 368 alice #test :End of channel ban list") {
+		t.Errorf("setting a ban gave %q", lines)
+	}
+	send(bob, "JOIN #test")
+	if lines := drain(bob); len(lines) != 1 || !strings.Contains(lines[0], " 474 bob #test ") {
+		t.Errorf("banned join gave %q", lines)
+	}
+
+	send(alice, "MODE #test +e-b+bb carol bob $m:$r:Robert nobody")
+	if lines := drain(alice); len(lines) != 2 || !strings.Contains(lines[0], " 478 alice #test nobody!*@* ") ||
+		lines[1] != ":alice!~alice@127.0.0.1 MODE #test +e-b+b carol!*@* bob!*@* $m:$r:Robert" {
+		t.Errorf("MAXLIST gave %q", lines)
+	}
+	send(bob, "JOIN #test")
+	send(bob, "PRIVMSG #test :hello")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 404 ") {
+		t.Errorf("muted user got %q", lines)
+	}
+
+	send(alice, "MODE #test -e+I carol $a")
+	send(alice, "MODE #test +i")
+	send(bob, "PART #test")
+	send(bob, "JOIN #test")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 366 bob #test ") {
+		t.Errorf("invite exception didn't let bob in: %q", lines)
+	}
+	send(bob, "MODE #test +e")
+	if lines := drain(bob); len(lines) != 1 || !strings.HasSuffix(lines[0], " 349 bob #test :End of channel exception list") {
+		t.Errorf("exception list gave %q", lines)
+	}
+}
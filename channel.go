@@ -0,0 +1,503 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Channel struct {
+	Name      string                   // As it was first created.
+	Members   map[*ircUser]*Membership // Everyone in the channel.
+	Topic     string
+	TopicBy   string    // nick!user@host of whoever set the topic.
+	TopicTime time.Time // When the topic was set.
+	Created   time.Time
+	Modes     map[byte]string      // Set modes and their parameter, "" for flags.
+	Lists     map[byte][]listEntry // Bans, exceptions and invite exceptions, oldest first.
+	LastKnock time.Time
+	Server    *Server
+}
+
+type Membership struct {
+	Joined time.Time
+	Modes  string // Prefix modes, highest rank first.
+}
+
+// Modes new channels start with.
+const defaultChannelModes = "nt"
+
+func init() {
+	registerCap(capability{Name: "multi-prefix"})
+	registerCap(capability{Name: "userhost-in-names"})
+}
+
+func (server *Server) findChannel(name string) (*Channel, bool) {
+	channel, ok := server.Channels[server.casefold(name)]
+	return channel, ok
+}
+
+func (server *Server) isValidChannel(name string) bool {
+	// #name, no spaces, commas or ^G, and no longer than CHANNELLEN.
+	return len(name) > 1 && name[0] == '#' && len(name) <= server.Config.Limits.ChannelLen &&
+		!strings.ContainsAny(name, " ,\x07")
+}
+
+func (channel *Channel) isMember(user *ircUser) bool {
+	_, ok := channel.Members[user]
+	return ok
+}
+
+func (channel *Channel) send(source string, command string, params ...string) {
+	// Sends a message to every member, in the order they'll each see it.
+	for member := range channel.Members {
+		member.sendMessage(source, command, params...)
+	}
+}
+
+func (channel *Channel) sendExcept(except *ircUser, source string, command string, params ...string) {
+	for member := range channel.Members {
+		if member != except {
+			member.sendMessage(source, command, params...)
+		}
+	}
+}
+
+func (channel *Channel) hasMode(mode byte) bool {
+	_, ok := channel.Modes[mode]
+	return ok
+}
+
+func (member *Membership) rank() int {
+	// Rank of the member's highest prefix mode.
+	if member.Modes == "" {
+		return 0
+	}
+	return channelModes.rank(member.Modes[0])
+}
+
+func (member *Membership) setMode(mode byte, adding bool) {
+	modes := strings.ReplaceAll(member.Modes, string(mode), "")
+	if adding {
+		modes += string(mode)
+	}
+	member.Modes = ""
+	for _, def := range channelModes { // Back into rank order.
+		if def.Type == modePrefix && strings.IndexByte(modes, def.Char) >= 0 {
+			member.Modes += string(def.Char)
+		}
+	}
+}
+
+func (member *Membership) prefixes(all bool) string {
+	// The member's prefix symbols. Only the highest one unless all is set, for multi-prefix.
+	prefixes := ""
+	for i := range len(member.Modes) {
+		if def, ok := channelModes.find(member.Modes[i]); ok {
+			prefixes += string(def.Prefix)
+		}
+	}
+	if !all && len(prefixes) > 1 {
+		return prefixes[:1]
+	}
+	return prefixes
+}
+
+func (channel *Channel) hasRank(user *ircUser, mode byte) bool {
+	// Whether user is a member with mode, or a prefix mode above it.
+	member, ok := channel.Members[user]
+	return ok && member.rank() >= channelModes.rank(mode)
+}
+
+func (channel *Channel) canSend(user *ircUser) bool {
+	member, ok := channel.Members[user]
+	if !ok {
+		return !channel.hasMode('n') && !channel.isMuted(user)
+	}
+	if member.Modes != "" {
+		return true // Any prefix lets a member speak.
+	}
+	return !channel.hasMode('m') && !channel.isMuted(user)
+}
+
+func (channel *Channel) joinError(user *ircUser, key string) (numeric string, reason string) {
+	// Why a user can't join, or "" if they can.
+	switch {
+	case channel.isBanned(user):
+		return ERR_BANNEDFROMCHAN, "+b"
+	case user.Invites[channel]:
+		return "", "" // An invite gets past everything but bans.
+	case channel.hasMode('i') && !channel.listMatches('I', user, false):
+		return ERR_INVITEONLYCHAN, "+i"
+	case channel.hasMode('k') && key != channel.Modes['k']:
+		return ERR_BADCHANNELKEY, "+k"
+	case channel.hasMode('l') && len(channel.Members) >= channel.limit():
+		return ERR_CHANNELISFULL, "+l"
+	}
+	return "", ""
+}
+
+func (channel *Channel) limit() int {
+	limit, _ := strconv.Atoi(channel.Modes['l']) // Checked when it was set.
+	return limit
+}
+
+func (channel *Channel) isHidden() bool {
+	// Secret and private channels don't show up for people outside them.
+	return channel.hasMode('s') || channel.hasMode('p')
+}
+
+func (channel *Channel) visibleTo(user *ircUser) bool {
+	return !channel.isHidden() || channel.isMember(user)
+}
+
+func (user *ircUser) peers() map[*ircUser]bool {
+	// Everyone sharing at least one channel with user, not counting user.
+	peers := make(map[*ircUser]bool)
+	for channel := range user.Channels {
+		for member := range channel.Members {
+			if member != user {
+				peers[member] = true
+			}
+		}
+	}
+	return peers
+}
+
+func (user *ircUser) sendToPeers(includeSelf bool, command string, params ...string) {
+	// Each peer gets one copy, however many channels they share.
+	for peer := range user.peers() {
+		peer.sendMessage(user.Host, command, params...)
+	}
+	if includeSelf {
+		user.sendMessage(user.Host, command, params...)
+	}
+}
+
+func (user *ircUser) joinChannel(name string, key string) {
+	server := user.Server
+	if !server.isValidChannel(name) {
+		user.sendNumeric(ERR_NOSUCHCHANNEL, name, ":No such channel")
+		return
+	}
+	channel, exists := server.findChannel(name)
+	if exists && channel.isMember(user) {
+		return
+	}
+	if len(user.Channels) >= server.Config.Limits.ChanLimit {
+		user.sendNumeric(ERR_TOOMANYCHANNELS, name, ":You have joined too many channels")
+		return
+	}
+	membership := &Membership{Joined: time.Now()}
+	if !exists {
+		channel = &Channel{Name: name, Members: make(map[*ircUser]*Membership), Modes: make(map[byte]string), Lists: make(map[byte][]listEntry), Created: time.Now(), Server: server}
+		for i := range len(defaultChannelModes) {
+			channel.Modes[defaultChannelModes[i]] = ""
+		}
+		membership.Modes = "qo" // Whoever creates a channel runs it.
+		server.Channels[server.casefold(name)] = channel
+	} else if numeric, reason := channel.joinError(user, key); numeric != "" {
+		user.sendNumeric(numeric, channel.Name, ":Cannot join channel ("+reason+")")
+		return
+	}
+
+	channel.Members[user] = membership
+	user.Channels[channel] = true
+	delete(user.Invites, channel)
+	channel.send(user.Host, "JOIN", channel.Name)
+	if user.AWAY {
+		// Peers who track away states need to know about the newcomer's.
+		for member := range channel.Members {
+			if member != user && member.hasCap("away-notify") {
+				member.sendMessage(user.Host, "AWAY", user.AwayMsg)
+			}
+		}
+	}
+	if channel.Topic != "" {
+		user.sendTopic(channel)
+	}
+	user.sendNames(channel)
+}
+
+func (user *ircUser) partChannel(channel *Channel, command string, params ...string) {
+	// Tells the channel why user is leaving, then removes them.
+	channel.send(user.Host, command, params...)
+	channel.removeMember(user)
+}
+
+func (channel *Channel) removeMember(user *ircUser) {
+	delete(channel.Members, user)
+	delete(user.Channels, channel)
+	if len(channel.Members) == 0 {
+		delete(channel.Server.Channels, channel.Server.casefold(channel.Name))
+	}
+}
+
+func (user *ircUser) sendTopic(channel *Channel) {
+	if channel.Topic == "" {
+		user.sendNumeric(RPL_NOTOPICSET, channel.Name, ":No topic is set")
+		return
+	}
+	user.sendNumeric(RPL_TOPIC, channel.Name, ":"+channel.Topic)
+	user.sendNumeric(RPL_TOPICTIME, channel.Name, channel.TopicBy, strconv.FormatInt(channel.TopicTime.Unix(), 10))
+}
+
+func (user *ircUser) sendNames(channel *Channel) {
+	// RPL_NAMREPLY, split so no line goes over 512 bytes.
+	symbol := "=" // Public
+	if channel.hasMode('s') {
+		symbol = "@"
+	} else if channel.hasMode('p') {
+		symbol = "*"
+	}
+	prefix := ":" + user.Server.Host + " " + RPL_NAMREPLY + " " + user.Nick + " " + symbol + " " + channel.Name + " :"
+	var names []string
+	multiPrefix, userhost := user.hasCap("multi-prefix"), user.hasCap("userhost-in-names")
+	for member, membership := range channel.Members {
+		name := member.Nick
+		if userhost {
+			name = member.Host
+		}
+		names = append(names, membership.prefixes(multiPrefix)+name)
+	}
+	for _, line := range packLines(prefix, " ", names) {
+		user.sendNumeric(RPL_NAMREPLY, symbol, channel.Name, ":"+line)
+	}
+	user.sendNumeric(RPL_ENDOFNAMES, channel.Name, ":End of /NAMES list")
+}
+
+func IRC_JOIN(msg *ircMessage) (string, string) {
+	// JOIN <channel>{,<channel>} [<key>{,<key>}]
+	// JOIN 0 leaves every channel.
+	if msg.Params[0] == "0" {
+		for channel := range msg.User.Channels {
+			msg.User.partChannel(channel, "PART", channel.Name, "Left all channels")
+		}
+		return "", ""
+	}
+
+	var keys []string
+	if len(msg.Params) > 1 {
+		keys = strings.Split(msg.Params[1], ",")
+	}
+	for i, name := range strings.Split(msg.Params[0], ",") {
+		key := ""
+		if i < len(keys) {
+			key = keys[i]
+		}
+		msg.User.joinChannel(name, key)
+	}
+	return "", ""
+}
+
+func IRC_PART(msg *ircMessage) (string, string) {
+	// PART <channel>{,<channel>} [<reason>]
+	for _, name := range strings.Split(msg.Params[0], ",") {
+		channel, ok := msg.Server.findChannel(name)
+		if !ok {
+			msg.User.sendNumeric(ERR_NOSUCHCHANNEL, name, ":No such channel")
+			continue
+		}
+		if !channel.isMember(msg.User) {
+			msg.User.sendNumeric(ERR_NOTONCHANNEL, channel.Name, ":You're not on that channel")
+			continue
+		}
+		if len(msg.Params) > 1 {
+			msg.User.partChannel(channel, "PART", channel.Name, msg.Params[1])
+		} else {
+			msg.User.partChannel(channel, "PART", channel.Name)
+		}
+	}
+	return "", ""
+}
+
+func IRC_NAMES(msg *ircMessage) (string, string) {
+	// NAMES [<channel>{,<channel>}]
+	if len(msg.Params) == 0 {
+		return RPL_ENDOFNAMES, "* :End of /NAMES list"
+	}
+	for _, name := range strings.Split(msg.Params[0], ",") {
+		if channel, ok := msg.Server.findChannel(name); ok && channel.visibleTo(msg.User) {
+			msg.User.sendNames(channel)
+		} else {
+			msg.User.sendNumeric(RPL_ENDOFNAMES, name, ":End of /NAMES list")
+		}
+	}
+	return "", ""
+}
+
+func IRC_TOPIC(msg *ircMessage) (string, string) {
+	// TOPIC <channel> [<topic>]
+	channel, ok := msg.Server.findChannel(msg.Params[0])
+	if !ok {
+		return ERR_NOSUCHCHANNEL, msg.Params[0] + " :No such channel"
+	}
+	if len(msg.Params) == 1 {
+		if !channel.visibleTo(msg.User) {
+			return ERR_NOTONCHANNEL, channel.Name + " :You're not on that channel"
+		}
+		msg.User.sendTopic(channel)
+		return "", ""
+	}
+	if !channel.isMember(msg.User) {
+		return ERR_NOTONCHANNEL, channel.Name + " :You're not on that channel"
+	}
+	if channel.hasMode('t') && !channel.hasRank(msg.User, 'h') {
+		return ERR_CHANOPRIVSNEEDED, channel.Name + " :You're not a channel operator"
+	}
+
+	topic := truncate(msg.Params[1], msg.Server.Config.Limits.TopicLen)
+	channel.Topic, channel.TopicBy, channel.TopicTime = topic, msg.User.Host, time.Now()
+	channel.send(msg.User.Host, "TOPIC", channel.Name, topic)
+	return "", ""
+}
+
+func (channel *Channel) modeIs(showKey bool) []string {
+	// The channel's modes and their parameters, for RPL_CHANNELMODEIS.
+	var changes []modeChange
+	for _, def := range channelModes {
+		if value, ok := channel.Modes[def.Char]; ok {
+			if def.Char == 'k' && !showKey {
+				value = "*"
+			}
+			changes = append(changes, modeChange{Adding: true, Mode: def.Char, Param: value})
+		}
+	}
+	if len(changes) == 0 {
+		return []string{"+"}
+	}
+	return formatModes(changes)
+}
+
+func validChannelMode(change *modeChange) bool {
+	// Checks, and tidies up, the parameter of a mode being set.
+	if def, _ := channelModes.find(change.Mode); def.Type == modeList {
+		mask, ok := normalizeMask(change.Param)
+		change.Param = mask
+		return ok
+	}
+	if !change.Adding {
+		return true
+	}
+	switch change.Mode {
+	case 'k':
+		return change.Param != "" && !strings.ContainsAny(change.Param, " ,:")
+	case 'l':
+		limit, err := strconv.Atoi(change.Param)
+		if err != nil || limit < 1 {
+			return false
+		}
+		change.Param = strconv.Itoa(limit)
+	}
+	return true
+}
+
+func (channel *Channel) mayChange(user *ircUser, target *ircUser, change modeChange) bool {
+	// Halfops and up run the channel. Prefix modes need the mode's SetBy rank,
+	// and nobody can take modes from someone ranked above them.
+	def, _ := channelModes.find(change.Mode)
+	if def.Type != modePrefix {
+		return channel.hasRank(user, 'h')
+	}
+	if target == user && !change.Adding {
+		return true // Anyone can step down.
+	}
+	if !change.Adding && channel.Members[target].rank() > channel.Members[user].rank() {
+		return false
+	}
+	return channel.hasRank(user, def.SetBy)
+}
+
+func (channel *Channel) modeRedundant(change modeChange) bool {
+	def, _ := channelModes.find(change.Mode)
+	if def.Type == modeList {
+		return (channel.findEntry(change.Mode, change.Param) >= 0) == change.Adding
+	}
+	if def.Type == modePrefix {
+		target, _ := channel.Server.findUser(change.Param)
+		has := strings.IndexByte(channel.Members[target].Modes, change.Mode) >= 0
+		return has == change.Adding
+	}
+	value, set := channel.Modes[change.Mode]
+	if !change.Adding {
+		return !set
+	}
+	return set && value == change.Param
+}
+
+func (user *ircUser) channelMode(msg *ircMessage) (string, string) {
+	channel, ok := msg.Server.findChannel(msg.Params[0])
+	if !ok {
+		return ERR_NOSUCHCHANNEL, msg.Params[0] + " :No such channel"
+	}
+	if len(msg.Params) == 1 {
+		if !channel.visibleTo(user) {
+			return ERR_NOTONCHANNEL, channel.Name + " :You're not on that channel"
+		}
+		user.sendNumeric(RPL_CHANNELMODEIS, append([]string{channel.Name}, channel.modeIs(channel.isMember(user))...)...)
+		user.sendNumeric(RPL_CHANNELCREATED, channel.Name, strconv.FormatInt(channel.Created.Unix(), 10))
+		return "", ""
+	}
+	if !channel.isMember(user) {
+		return ERR_NOTONCHANNEL, channel.Name + " :You're not on that channel"
+	}
+
+	changes, unknown := channelModes.parse(msg.Params[1], msg.Params[2:], msg.Server.Config.Limits.Modes)
+	for _, char := range unknown {
+		user.sendNumeric(ERR_UNKNOWNMODE, string(char), ":is unknown mode char to me for "+channel.Name)
+	}
+	valid, denied := changes[:0], false
+	for _, change := range changes {
+		if def, _ := channelModes.find(change.Mode); def.Type == modeList && change.Param == "" {
+			user.sendList(channel, change.Mode)
+			continue
+		}
+		if !validChannelMode(&change) {
+			continue
+		}
+		var target *ircUser
+		if def, _ := channelModes.find(change.Mode); def.Type == modePrefix {
+			if target, ok = msg.Server.findUser(change.Param); !ok {
+				user.sendNumeric(ERR_NOSUCHNICK, change.Param, ":No such nick/channel")
+				continue
+			}
+			if !channel.isMember(target) {
+				user.sendNumeric(ERR_USERNOTINCHANNEL, target.Nick, channel.Name, ":They aren't on that channel")
+				continue
+			}
+			change.Param = target.Nick
+		}
+		if !channel.mayChange(user, target, change) {
+			denied = true
+			continue
+		}
+		valid = append(valid, change)
+	}
+	if denied {
+		user.sendNumeric(ERR_CHANOPRIVSNEEDED, channel.Name, ":You don't have enough channel privileges for that")
+	}
+	applied := []modeChange{}
+	for _, change := range channelModes.collapse(valid, msg.Server.casefold, channel.modeRedundant) {
+		switch def, _ := channelModes.find(change.Mode); {
+		case def.Type == modeList:
+			if change.Adding && channel.listSize() >= msg.Server.Config.Limits.MaxList {
+				user.sendNumeric(ERR_BANLISTFULL, channel.Name, change.Param, ":Channel list is full")
+				continue
+			}
+			channel.setListEntry(user, change)
+		case def.Type == modePrefix:
+			target, _ := msg.Server.findUser(change.Param)
+			channel.Members[target].setMode(change.Mode, change.Adding)
+		case change.Adding:
+			channel.Modes[change.Mode] = change.Param
+		default:
+			delete(channel.Modes, change.Mode)
+		}
+		applied = append(applied, change)
+	}
+	if len(applied) == 0 {
+		return "", ""
+	}
+	channel.send(user.Host, "MODE", append([]string{channel.Name}, formatModes(applied)...)...)
+	return "", ""
+}
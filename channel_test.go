@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func mock_client(server *Server, nick string) *ircUser {
+	// A registered user whose output is kept for the test to read.
+	user := &ircUser{Nick: nick, User: nick, Server: server, State: stateRegistered}
+	user.Channels = make(map[*Channel]bool)
+	user.Writer = make(chan string, 1000)
+	user.Conn = mockConn()
+	user.updateUser()
+	return user
+}
+
+func send(user *ircUser, line string) {
+	msg := mock_message(line, user)
+	msg.handleCommand()
+}
+
+func drain(user *ircUser) (lines []string) {
+	for {
+		select {
+		case line := <-user.Writer:
+			lines = append(lines, line)
+		default:
+			return
+		}
+	}
+}
+
+func Test_Join_Part(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+
+	send(alice, "JOIN #Test,#other")
+	send(bob, "JOIN #test")
+	lines := strings.Join(drain(alice), "\n")
+	if !strings.Contains(lines, ":bob!~bob@127.0.0.1 JOIN #Test") {
+		t.Errorf("alice didn't see bob join:\n%s", lines)
+	}
+	names := strings.Join(drain(bob), "\n")
+	if !strings.Contains(names, " 353 bob = #Test :") || !strings.Contains(names, "alice") ||
+		!strings.Contains(names, " 366 bob #Test :End of /NAMES list") {
+		t.Errorf("bob got the wrong NAMES:\n%s", names)
+	}
+
+	send(bob, "PART #TEST :see you")
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 PART #Test :see you" {
+		t.Errorf("alice got %q", lines)
+	}
+	drain(bob)
+	send(bob, "PART #test")
+	if lines := drain(bob); len(lines) != 1 || !strings.Contains(lines[0], " 442 ") {
+		t.Errorf("parting twice gave %q", lines)
+	}
+
+	send(alice, "JOIN 0")
+	drain(alice)
+	if len(server.Channels) != 0 || len(alice.Channels) != 0 {
+		t.Errorf("JOIN 0 left %d channels behind", len(server.Channels))
+	}
+
+	send(alice, "JOIN nohash")
+	if lines := drain(alice); len(lines) != 1 || !strings.Contains(lines[0], " 403 ") {
+		t.Errorf("invalid channel gave %q", lines)
+	}
+}
+
+func Test_Topic(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #test")
+	send(alice, "TOPIC #test :hello world")
+	if lines := drain(alice); lines[len(lines)-1] != ":alice!~alice@127.0.0.1 TOPIC #test :hello world" {
+		t.Errorf("topic change gave %q", lines)
+	}
+
+	send(bob, "TOPIC #test :mine now")
+	if lines := drain(bob); len(lines) != 1 || !strings.Contains(lines[0], " 442 ") {
+		t.Errorf("non-member setting the topic gave %q", lines)
+	}
+	send(bob, "JOIN #test")
+	lines := drain(bob)
+	if len(lines) < 3 || lines[1] != ":TestIRCd.testserver.net 332 bob #test :hello world" ||
+		!strings.HasPrefix(lines[2], ":TestIRCd.testserver.net 333 bob #test alice!~alice@127.0.0.1 ") {
+		t.Errorf("joining got %q", lines)
+	}
+}
+
+func Test_Channel_Quit(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #a,#b")
+	send(bob, "JOIN #a,#b")
+	drain(alice)
+
+	send(bob, "NICK robert")
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 NICK robert" {
+		t.Errorf("nick change gave %q", lines)
+	}
+	send(bob, "QUIT :bye")
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":robert!~bob@127.0.0.1 QUIT :Quit: bye" {
+		t.Errorf("quit gave %q", lines)
+	}
+	for _, channel := range server.Channels {
+		if channel.isMember(bob) {
+			t.Errorf("bob is still in %s", channel.Name)
+		}
+	}
+}
+
+func Test_Channel_Modes_Enforced(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #test")
+	send(alice, "MODE #test +kl key 2")
+	send(alice, "TOPIC #test :ops only")
+	drain(alice)
+
+	send(bob, "JOIN #test")
+	send(bob, "JOIN #test wrong")
+	send(bob, "TOPIC #test :mine")
+	lines := drain(bob)
+	if len(lines) != 3 || !strings.Contains(lines[0], " 475 bob #test ") || !strings.Contains(lines[1], " 475 ") ||
+		!strings.Contains(lines[2], " 442 ") {
+		t.Errorf("joining with a bad key gave %q", lines)
+	}
+	send(bob, "JOIN #test key")
+	send(bob, "TOPIC #test :mine")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 482 bob #test ") {
+		t.Errorf("+t let bob change the topic: %q", lines)
+	}
+	carol := mock_client(server, "carol")
+	send(carol, "JOIN #test key")
+	send(carol, "PRIVMSG #test :hi")
+	if lines := drain(carol); len(lines) != 2 || !strings.Contains(lines[0], " 471 ") || !strings.Contains(lines[1], " 404 ") {
+		t.Errorf("full channel gave %q", lines)
+	}
+
+	send(alice, "MODE #test +ims-l")
+	drain(alice)
+	send(bob, "PRIVMSG #test :hello?")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 404 bob #test ") {
+		t.Errorf("+m let bob speak: %q", lines)
+	}
+	send(carol, "JOIN #test key")
+	send(carol, "NAMES #test")
+	if lines := drain(carol); len(lines) != 2 || !strings.Contains(lines[0], " 473 ") || !strings.HasSuffix(lines[1], " 366 carol #test :End of /NAMES list") {
+		t.Errorf("secret invite-only channel gave %q", lines)
+	}
+	send(alice, "NAMES #test")
+	if lines := drain(alice); len(lines) != 2 || !strings.Contains(lines[0], " 353 alice @ #test ") {
+		t.Errorf("secret NAMES gave %q", lines)
+	}
+}
+
+func Test_Channel_Prefixes(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	send(alice, "JOIN #test")
+	send(bob, "JOIN #test")
+	send(carol, "JOIN #test")
+	drain(alice)
+	drain(bob)
+	drain(carol)
+
+	send(alice, "MODE #test +ov-v BOB carol carol")
+	if lines := drain(carol); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 MODE #test +o bob" {
+		t.Errorf("carol got %q", lines)
+	}
+	send(bob, "MODE #test +a bob")
+	send(bob, "MODE #test -q alice")
+	send(bob, "MODE #test +v nobody")
+	lines := drain(bob)
+	if len(lines) != 4 || !strings.Contains(lines[1], " 482 bob #test ") || !strings.Contains(lines[2], " 482 bob #test ") ||
+		!strings.Contains(lines[3], " 401 bob nobody ") {
+		t.Errorf("bob got %q", lines)
+	}
+
+	send(bob, "NAMES #test")
+	names := " " + strings.SplitN(drain(bob)[0], " :", 2)[1] + " "
+	if !strings.Contains(names, " ~alice ") || !strings.Contains(names, " @bob ") || !strings.Contains(names, " carol ") {
+		t.Errorf("NAMES gave %q", names)
+	}
+	carol.Caps = map[string]bool{"multi-prefix": true, "userhost-in-names": true}
+	send(carol, "NAMES #test")
+	if names := drain(carol)[0]; !strings.Contains(names, "~@alice!~alice@127.0.0.1") {
+		t.Errorf("NAMES with caps gave %q", names)
+	}
+
+	send(bob, "MODE #test -o bob")
+	send(bob, "TOPIC #test :can't")
+	if lines := drain(bob); len(lines) != 2 || !strings.Contains(lines[1], " 482 ") {
+		t.Errorf("stepping down gave %q", lines)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	knockUserDelay    = 5 * time.Minute // Between KNOCKs from the same user.
+	knockChannelDelay = time.Minute     // Between KNOCKs on the same channel, from anyone.
+)
+
+func init() {
+	registerCap(capability{Name: "invite-notify"})
+}
+
+func (channel *Channel) sendToRank(mode byte, except *ircUser, send func(member *ircUser)) {
+	// Calls send for every member with mode or a prefix above it.
+	for member := range channel.Members {
+		if member != except && channel.hasRank(member, mode) {
+			send(member)
+		}
+	}
+}
+
+func IRC_KICK(msg *ircMessage) (string, string) {
+	// KICK <channel>{,<channel>} <user>{,<user>} [<comment>]
+	// Either one channel and any number of users, or channels and users in pairs.
+	channels, targets := strings.Split(msg.Params[0], ","), strings.Split(msg.Params[1], ",")
+	if len(channels) > 1 && len(channels) < len(targets) {
+		targets = targets[:len(channels)] // users without a channel to go with
+	}
+	if limit := msg.Server.Config.Limits.MaxTargets; len(targets) > limit {
+		return ERR_TOOMANYTARGETS, msg.Params[1] + " :Too many targets, the maximum is " + strconv.Itoa(limit)
+	}
+	reason := msg.User.Nick
+	if len(msg.Params) > 2 && msg.Params[2] != "" {
+		reason = msg.Params[2]
+	}
+	reason = truncate(reason, msg.Server.Config.Limits.KickLen)
+
+	for i, nick := range targets {
+		name := channels[0]
+		if len(channels) > 1 {
+			name = channels[i]
+		}
+		msg.User.kick(name, nick, reason)
+	}
+	return "", ""
+}
+
+func (user *ircUser) kick(name string, nick string, reason string) {
+	// Halfops and up can kick anyone who doesn't outrank them.
+	channel, ok := user.Server.findChannel(name)
+	if !ok {
+		user.sendNumeric(ERR_NOSUCHCHANNEL, name, ":No such channel")
+		return
+	}
+	if !channel.isMember(user) {
+		user.sendNumeric(ERR_NOTONCHANNEL, channel.Name, ":You're not on that channel")
+		return
+	}
+	target, ok := user.Server.findUser(nick)
+	if !ok {
+		user.sendNumeric(ERR_NOSUCHNICK, nick, ":No such nick/channel")
+		return
+	}
+	if !channel.isMember(target) {
+		user.sendNumeric(ERR_USERNOTINCHANNEL, target.Nick, channel.Name, ":They aren't on that channel")
+		return
+	}
+	if !channel.hasRank(user, 'h') || channel.Members[target].rank() > channel.Members[user].rank() {
+		user.sendNumeric(ERR_CHANOPRIVSNEEDED, channel.Name, ":You're not a channel operator")
+		return
+	}
+	channel.send(user.Host, "KICK", channel.Name, target.Nick, reason)
+	channel.removeMember(target)
+}
+
+func IRC_INVITE(msg *ircMessage) (string, string) {
+	// INVITE <nick> <channel>
+	target, ok := msg.Server.findUser(msg.Params[0])
+	if !ok {
+		return ERR_NOSUCHNICK, msg.Params[0] + " :No such nick/channel"
+	}
+	channel, ok := msg.Server.findChannel(msg.Params[1])
+	if !ok {
+		return ERR_NOSUCHCHANNEL, msg.Params[1] + " :No such channel"
+	}
+	if !channel.isMember(msg.User) {
+		return ERR_NOTONCHANNEL, channel.Name + " :You're not on that channel"
+	}
+	if channel.hasMode('i') && !channel.hasRank(msg.User, 'h') {
+		return ERR_CHANOPRIVSNEEDED, channel.Name + " :You're not a channel operator"
+	}
+	if channel.isMember(target) {
+		return ERR_USERONCHANNEL, target.Nick + " " + channel.Name + " :is already on channel"
+	}
+
+	if target.Invites == nil {
+		target.Invites = make(map[*Channel]bool)
+	}
+	target.Invites[channel] = true
+	msg.User.sendNumeric(RPL_INVITING, target.Nick, channel.Name)
+	target.sendMessage(msg.User.Host, "INVITE", target.Nick, channel.Name)
+	// Let the rest of the channel's staff know, if they asked to.
+	channel.sendToRank('h', msg.User, func(member *ircUser) {
+		if member.hasCap("invite-notify") {
+			member.sendMessage(msg.User.Host, "INVITE", target.Nick, channel.Name)
+		}
+	})
+	return "", ""
+}
+
+func IRC_KNOCK(msg *ircMessage) (string, string) {
+	// KNOCK <channel> [<message>]
+	// Asks the ops of a closed channel for an invite.
+	channel, ok := msg.Server.findChannel(msg.Params[0])
+	if !ok || !channel.visibleTo(msg.User) {
+		return ERR_NOSUCHCHANNEL, msg.Params[0] + " :No such channel"
+	}
+	if channel.isMember(msg.User) {
+		return ERR_KNOCKONCHAN, channel.Name + " :You're already on that channel"
+	}
+	if numeric, _ := channel.joinError(msg.User, ""); numeric == "" {
+		return ERR_CHANOPEN, channel.Name + " :Channel is open"
+	} else if numeric == ERR_BANNEDFROMCHAN {
+		return ERR_BANNEDFROMCHAN, channel.Name + " :Cannot knock on channel (you're banned)"
+	}
+	now := time.Now()
+	if now.Sub(msg.User.LastKnock) < knockUserDelay {
+		return ERR_TOOMANYKNOCK, channel.Name + " :Too many KNOCKs (user)"
+	}
+	if now.Sub(channel.LastKnock) < knockChannelDelay {
+		return ERR_TOOMANYKNOCK, channel.Name + " :Too many KNOCKs (channel)"
+	}
+	msg.User.LastKnock, channel.LastKnock = now, now
+
+	text := "has asked for an invite."
+	if len(msg.Params) > 1 && msg.Params[1] != "" {
+		text = "has asked for an invite (" + msg.Params[1] + ")"
+	}
+	channel.sendToRank('h', nil, func(member *ircUser) {
+		member.sendNumeric(RPL_KNOCK, channel.Name, msg.User.Host, ":"+text)
+	})
+	return RPL_KNOCKDLVR, channel.Name + " :Your KNOCK has been delivered."
+}
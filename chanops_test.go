@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Kick(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	for _, user := range []*ircUser{alice, bob, carol} {
+		send(user, "JOIN #test")
+	}
+	send(alice, "MODE #test +h bob")
+	drain(alice)
+	drain(bob)
+	drain(carol)
+
+	send(bob, "KICK #test alice")
+	send(bob, "KICK #test carol,nobody,dave :behave")
+	lines := drain(bob)
+	if len(lines) != 4 || !strings.Contains(lines[0], " 482 bob #test ") ||
+		lines[1] != ":bob!~bob@127.0.0.1 KICK #test carol behave" || !strings.Contains(lines[2], " 401 bob nobody ") ||
+		!strings.Contains(lines[3], " 401 bob dave ") {
+		t.Errorf("bob got %q", lines)
+	}
+	if lines := drain(carol); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 KICK #test carol behave" {
+		t.Errorf("carol got %q", lines)
+	}
+	channel, _ := server.findChannel("#test")
+	if channel.isMember(carol) {
+		t.Error("carol is still in #test")
+	}
+	drain(alice)
+
+	send(alice, "KICK #test carol")
+	if lines := drain(alice); len(lines) != 1 || !strings.Contains(lines[0], " 441 alice carol #test ") {
+		t.Errorf("kicking a non-member gave %q", lines)
+	}
+	send(alice, "KICK #test bob")
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 KICK #test bob alice" {
+		t.Errorf("default reason gave %q", lines)
+	}
+}
+
+func Test_Kick_Pairs(t *testing.T) {
+	server := mock_user().Server
+	server.Config.Limits.KickLen = 5
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	for _, name := range []string{"#a", "#b"} {
+		send(alice, "JOIN "+name)
+		send(bob, "JOIN "+name)
+		send(carol, "JOIN "+name)
+	}
+	drain(alice)
+
+	// The third user has no channel of its own, so only the pairs are kicked.
+	send(alice, "KICK #a,#b bob,carol,bob :go away now")
+	lines := drain(alice)
+	if len(lines) != 2 || lines[0] != ":alice!~alice@127.0.0.1 KICK #a bob :go aw" ||
+		lines[1] != ":alice!~alice@127.0.0.1 KICK #b carol :go aw" {
+		t.Errorf("pairwise KICK gave %q", lines)
+	}
+	a, _ := server.findChannel("#a")
+	b, _ := server.findChannel("#b")
+	if a.isMember(bob) || !a.isMember(carol) || b.isMember(carol) || !b.isMember(bob) {
+		t.Error("KICK didn't pair channels with users")
+	}
+	if !strings.Contains(strings.Join(server.isupport(), " "), " KICKLEN=5 ") {
+		t.Error("KICKLEN isn't advertised")
+	}
+}
+
+func Test_Invite_Knock(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	carol.Caps = map[string]bool{"invite-notify": true}
+	send(alice, "JOIN #test")
+	send(carol, "JOIN #test")
+	send(alice, "MODE #test +ilk 2 key")
+	send(alice, "MODE #test +o carol")
+	drain(alice)
+	drain(carol)
+
+	send(bob, "KNOCK #test :let me in")
+	if lines := drain(bob); len(lines) != 1 || !strings.HasSuffix(lines[0], " 711 bob #test :Your KNOCK has been delivered.") {
+		t.Errorf("knocking gave %q", lines)
+	}
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":TestIRCd.testserver.net 710 alice #test bob!~bob@127.0.0.1 :has asked for an invite (let me in)" {
+		t.Errorf("alice got %q", lines)
+	}
+	send(bob, "KNOCK #test")
+	bob.LastKnock = time.Time{}
+	send(bob, "KNOCK #test")
+	if lines := drain(bob); len(lines) != 2 || !strings.HasSuffix(lines[0], " :Too many KNOCKs (user)") ||
+		!strings.HasSuffix(lines[1], " :Too many KNOCKs (channel)") {
+		t.Errorf("knocking again gave %q", lines)
+	}
+
+	send(alice, "INVITE bob #test")
+	if lines := drain(alice); len(lines) != 1 || !strings.HasSuffix(lines[0], " 341 alice bob #test") {
+		t.Errorf("alice got %q", lines)
+	}
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 INVITE bob #test" {
+		t.Errorf("bob got %q", lines)
+	}
+	if lines := drain(carol); len(lines) != 2 || lines[1] != ":alice!~alice@127.0.0.1 INVITE bob #test" {
+		t.Errorf("carol got %q", lines)
+	}
+
+	send(bob, "JOIN #test")
+	send(bob, "KNOCK #test")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 714 bob #test ") {
+		t.Errorf("invited join gave %q", lines)
+	}
+	send(bob, "PART #test")
+	send(bob, "JOIN #test")
+	if lines := drain(bob); !strings.Contains(lines[len(lines)-1], " 473 bob #test ") {
+		t.Errorf("the invite was used twice: %q", lines)
+	}
+	drain(alice)
+	send(alice, "INVITE carol #test")
+	if lines := drain(alice); len(lines) != 1 || !strings.Contains(lines[0], " 443 alice carol #test ") {
+		t.Errorf("inviting a member gave %q", lines)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Server    ServerConfig     `json:"server"`
+	Listeners []ListenerConfig `json:"listeners"`
+	Limits    LimitsConfig     `json:"limits"`
+	Classes   []ClassConfig    `json:"classes"`
+	Opers     []OperConfig     `json:"opers"`
+	Accounts  []AccountConfig  `json:"accounts"`
+	Bans      []BanConfig      `json:"bans"`
+	TLS       TLSConfig        `json:"tls"`
+	STS       STSConfig        `json:"sts"`
+
+	Path    string    `json:"-"` // File this config was loaded from.
+	MOTD    []string  `json:"-"` // Contents of Server.MOTD, one entry per line.
+	tlsCert *certFile // Loaded from TLS.Cert and TLS.Key during validation.
+}
+
+type ServerConfig struct {
+	Name        string `json:"name"`        // Description, e.g. "Syed's FunHouse"
+	Host        string `json:"host"`        // Server name as clients see it
+	Network     string `json:"network"`     // Network name, sent in the welcome and ISUPPORT
+	Password    string `json:"password"`    // Connection password clients must PASS, optional
+	MOTD        string `json:"motd"`        // Path to the MOTD, relative to the config file
+	CaseMapping string `json:"casemapping"` // ascii, rfc1459 (default) or rfc7613
+}
+
+type ListenerConfig struct {
+	Network string `json:"network"` // tcp (default), tcp4, tcp6 or unix
+	Address string `json:"address"` // host:port, or a socket path for unix
+	Class   string `json:"class"`   // Connection class for clients on this listener
+	TLS     bool   `json:"tls"`     // Serve TLS with the certificate from the tls block
+}
+
+type TLSConfig struct {
+	Cert       string   `json:"cert"` // PEM files, relative to the config file
+	Key        string   `json:"key"`
+	MinVersion string   `json:"min_version"` // "1.2" (default) or "1.3"
+	Ciphers    []string `json:"ciphers"`     // crypto/tls suite names, TLS 1.2 only. Empty means Go's defaults.
+}
+
+type STSConfig struct {
+	Port     int  `json:"port"`     // TLS port plaintext clients should move to. 0 disables STS.
+	Duration int  `json:"duration"` // Seconds clients should remember the policy, required with a port
+	Preload  bool `json:"preload"`
+}
+
+type LimitsConfig struct {
+	NickLen    int `json:"nicklen"`
+	ChannelLen int `json:"channellen"`
+	ChanLimit  int `json:"chanlimit"` // Channels a client may be in at once
+	TopicLen   int `json:"topiclen"`
+	MaxTargets int `json:"maxtargets"` // Targets per PRIVMSG or NOTICE
+	Modes      int `json:"modes"`      // Modes with a parameter per MODE command
+	MaxList    int `json:"maxlist"`    // Entries in a channel's ban, exception and invex lists together
+	AwayLen    int `json:"awaylen"`
+	KickLen    int `json:"kicklen"`
+	Monitor    int `json:"monitor"` // Nicks each client may MONITOR
+}
+
+type ClassConfig struct {
+	Name        string `json:"name"`
+	SendQ       int    `json:"sendq"`       // Bytes queued for a client before it is dropped
+	PingFreq    int    `json:"pingfreq"`    // Seconds a client can be quiet before we PING it
+	PingTimeout int    `json:"pingtimeout"` // Seconds to wait for an answer before dropping it
+}
+
+type OperConfig struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password"` // May be left out when certfp is set
+	CertFP   string   `json:"certfp"`   // SHA-256 fingerprint the client certificate must have
+	Hosts    []string `json:"hosts"`    // user@host masks allowed to use this block
+}
+
+type BanConfig struct {
+	Mask   string `json:"mask"` // user@host mask refused at registration
+	Reason string `json:"reason"`
+}
+
+type AccountConfig struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password"`
+	CertFP   []string `json:"certfp"` // Certificates that log in to this account automatically
+}
+
+func defaultConfig() *Config {
+	// Anything left out of the config file keeps these values.
+	return &Config{
+		Server: ServerConfig{
+			Name:    "Syed's FunHouse",
+			Host:    "InitialIRCD.testserver.net",
+			Network: "FunHouse",
+		},
+		Limits: LimitsConfig{
+			NickLen:    9,
+			ChannelLen: 50,
+			ChanLimit:  20,
+			TopicLen:   390,
+			MaxTargets: 4,
+			Modes:      4,
+			MaxList:    100,
+			AwayLen:    200,
+			KickLen:    255,
+			Monitor:    100,
+		},
+	}
+}
+
+type configError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := defaultConfig()
+	conf.Path = path
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(conf); err != nil {
+		return nil, decodeError(path, data, err)
+	}
+
+	v := configValidator{file: path, data: data, offsets: make(map[string]int64)}
+	for _, key := range jsonKeys(data) {
+		v.offsets[key.Path] = key.Offset
+	}
+	v.validate(conf)
+	if len(v.errs) > 0 {
+		return nil, errors.Join(v.errs...)
+	}
+	return conf, nil
+}
+
+func decodeError(path string, data []byte, err error) error {
+	// Points JSON errors at a line rather than a byte offset.
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &configError{path, lineAt(data, syntaxErr.Offset), syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		return &configError{path, lineAt(data, typeErr.Offset), fmt.Sprintf("%s should be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// No offset for these, so find the first key with nowhere to go,
+		// the same one encoding/json stops at.
+		for _, key := range jsonKeys(data) {
+			if !knownSetting(reflect.TypeOf(Config{}), key.Path) {
+				return &configError{path, lineAt(data, key.Offset), "unknown setting " + key.Path}
+			}
+		}
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &configError{path, 1, "unknown setting " + field}
+	}
+	return &configError{path, 1, err.Error()}
+}
+
+type jsonKey struct {
+	Path   string // "listeners[0].address"
+	Offset int64  // Where its value starts
+}
+
+func jsonKeys(data []byte) []jsonKey {
+	// Every setting path in the file, in the order they appear.
+	var keys []jsonKey
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, jsonKey{path, dec.InputOffset() - 1})
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child := key.(string)
+				if path != "" {
+					child = path + "." + child
+				}
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	return keys
+}
+
+func knownSetting(t reflect.Type, path string) bool {
+	// Follows a setting path through the struct it decodes into.
+	if path == "" {
+		return true
+	}
+	for _, part := range strings.Split(path, ".") {
+		name, _, _ := strings.Cut(part, "[")
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if name != "" {
+			if t.Kind() != reflect.Struct {
+				return true // Decoded as a whole, anything goes inside.
+			}
+			field, ok := jsonField(t, name)
+			if !ok {
+				return false
+			}
+			t = field.Type
+		}
+		for depth := strings.Count(part, "["); depth > 0; depth-- {
+			if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+				return true
+			}
+			t = t.Elem()
+		}
+	}
+	return true
+}
+
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	// Matches keys to fields the way encoding/json does, ignoring case.
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+type configValidator struct {
+	file    string
+	data    []byte
+	offsets map[string]int64
+	errs    []error
+}
+
+func (v *configValidator) errorf(path string, format string, args ...interface{}) {
+	// Reports at the setting itself, or the closest enclosing block if it's missing.
+	line := 1
+	for p := path; ; {
+		if offset, ok := v.offsets[p]; ok {
+			line = lineAt(v.data, offset)
+			break
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	v.errs = append(v.errs, &configError{v.file, line, path + ": " + fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) validate(conf *Config) {
+	if conf.Server.Host == "" || strings.ContainsAny(conf.Server.Host, " !@") || !strings.Contains(conf.Server.Host, ".") {
+		v.errorf("server.host", "%q is not a valid server name", conf.Server.Host)
+	}
+	if conf.Server.Network == "" || strings.Contains(conf.Server.Network, " ") {
+		v.errorf("server.network", "%q is not a valid network name", conf.Server.Network)
+	}
+	if _, ok := caseMappings[conf.Server.caseMapping()]; !ok {
+		v.errorf("server.casemapping", "%q is not one of ascii, rfc1459 or rfc7613", conf.Server.CaseMapping)
+	}
+	if conf.Server.MOTD != "" {
+		if lines, err := readLines(v.relative(conf.Server.MOTD)); err != nil {
+			v.errorf("server.motd", "%v", err)
+		} else {
+			conf.MOTD = lines
+		}
+	}
+
+	// Every config gets a default class, so listeners can leave it out.
+	classes := map[string]bool{"default": true}
+	for i, class := range conf.Classes {
+		path := fmt.Sprintf("classes[%d]", i)
+		if class.Name == "" {
+			v.errorf(path+".name", "class needs a name")
+		} else if classes[class.Name] && class.Name != "default" {
+			v.errorf(path+".name", "class %q is defined twice", class.Name)
+		}
+		classes[class.Name] = true
+		if class.SendQ < 0 {
+			v.errorf(path+".sendq", "sendq can't be negative")
+		}
+		if class.PingFreq < 0 {
+			v.errorf(path+".pingfreq", "pingfreq can't be negative")
+		}
+		if class.PingTimeout < 0 {
+			v.errorf(path+".pingtimeout", "pingtimeout can't be negative")
+		}
+	}
+
+	if len(conf.Listeners) == 0 {
+		v.errorf("listeners", "at least one listener is required")
+	}
+	for i, l := range conf.Listeners {
+		path := fmt.Sprintf("listeners[%d]", i)
+		switch l.network() {
+		case "tcp", "tcp4", "tcp6":
+			if _, _, err := net.SplitHostPort(l.Address); err != nil {
+				v.errorf(path+".address", "%v", err)
+			}
+		case "unix":
+			if l.Address == "" {
+				v.errorf(path+".address", "unix listeners need a socket path")
+			}
+		default:
+			v.errorf(path+".network", "unknown network %q", l.Network)
+		}
+		if l.Class != "" && !classes[l.Class] {
+			v.errorf(path+".class", "no class named %q", l.Class)
+		}
+	}
+
+	v.validateTLS(conf)
+
+	limits := []struct {
+		path  string
+		value int
+		max   int
+	}{
+		{"limits.nicklen", conf.Limits.NickLen, 32},
+		{"limits.channellen", conf.Limits.ChannelLen, 64},
+		{"limits.chanlimit", conf.Limits.ChanLimit, 1000},
+		{"limits.topiclen", conf.Limits.TopicLen, 400},
+		{"limits.maxtargets", conf.Limits.MaxTargets, 20},
+		{"limits.modes", conf.Limits.Modes, 20},
+		{"limits.maxlist", conf.Limits.MaxList, 1000},
+		{"limits.awaylen", conf.Limits.AwayLen, 400},
+		{"limits.kicklen", conf.Limits.KickLen, 400},
+		{"limits.monitor", conf.Limits.Monitor, 1000},
+	}
+	for _, l := range limits {
+		if l.value < 1 || l.value > l.max {
+			v.errorf(l.path, "must be between 1 and %d", l.max)
+		}
+	}
+
+	for i, oper := range conf.Opers {
+		path := fmt.Sprintf("opers[%d]", i)
+		if oper.Name == "" || strings.Contains(oper.Name, " ") {
+			v.errorf(path+".name", "%q is not a valid oper name", oper.Name)
+		}
+		if oper.Password == "" && oper.CertFP == "" {
+			v.errorf(path+".password", "oper blocks need a password or certfp")
+		}
+		if oper.CertFP != "" && !validCertFP(oper.CertFP) {
+			v.errorf(path+".certfp", "%q is not a SHA-256 fingerprint", oper.CertFP)
+		}
+		for j, mask := range oper.Hosts {
+			if !strings.Contains(mask, "@") {
+				v.errorf(fmt.Sprintf("%s.hosts[%d]", path, j), "%q should look like user@host", mask)
+			}
+		}
+	}
+
+	for i, ban := range conf.Bans {
+		if !strings.Contains(ban.Mask, "@") {
+			v.errorf(fmt.Sprintf("bans[%d].mask", i), "%q should look like user@host", ban.Mask)
+		}
+	}
+
+	for i, account := range conf.Accounts {
+		path := fmt.Sprintf("accounts[%d]", i)
+		if account.Name == "" || account.Password == "" {
+			v.errorf(path, "accounts need a name and password")
+		}
+		for j, fp := range account.CertFP {
+			if !validCertFP(fp) {
+				v.errorf(fmt.Sprintf("%s.certfp[%d]", path, j), "%q is not a SHA-256 fingerprint", fp)
+			}
+		}
+	}
+}
+
+func (v *configValidator) validateTLS(conf *Config) {
+	tlsPorts := make(map[string]bool)
+	for _, l := range conf.Listeners {
+		if l.TLS {
+			_, port, _ := net.SplitHostPort(l.Address)
+			tlsPorts[port] = true
+		}
+	}
+
+	if conf.TLS.Cert != "" || conf.TLS.Key != "" || len(tlsPorts) > 0 {
+		cert, err := loadCertFile(v.relative(conf.TLS.Cert), v.relative(conf.TLS.Key))
+		if err != nil {
+			v.errorf("tls.cert", "%v", err)
+		} else {
+			conf.tlsCert = cert
+		}
+	}
+	if _, ok := tlsVersions[conf.TLS.minVersion()]; !ok {
+		v.errorf("tls.min_version", "%q isn't one of 1.2, 1.3", conf.TLS.MinVersion)
+	}
+	suites := cipherSuites()
+	for i, name := range conf.TLS.Ciphers {
+		if _, ok := suites[name]; !ok {
+			v.errorf(fmt.Sprintf("tls.ciphers[%d]", i), "unknown or insecure cipher suite %q", name)
+		}
+	}
+
+	if conf.STS.Port != 0 && !tlsPorts[strconv.Itoa(conf.STS.Port)] {
+		v.errorf("sts.port", "no TLS listener on port %d", conf.STS.Port)
+	}
+	if conf.STS.Duration < 0 {
+		v.errorf("sts.duration", "duration can't be negative")
+	} else if conf.STS.Port != 0 && conf.STS.Duration == 0 {
+		// duration=0 tells clients to forget the policy, the opposite of turning STS on.
+		v.errorf("sts.duration", "duration is needed when sts.port is set")
+	}
+}
+
+func validCertFP(fp string) bool {
+	decoded, err := hex.DecodeString(normalizeCertFP(fp))
+	return err == nil && len(decoded) == sha256.Size
+}
+
+func (v *configValidator) relative(path string) string {
+	// Paths in the config are relative to the config file itself.
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(v.file), path)
+}
+
+func (conf TLSConfig) minVersion() string {
+	if conf.MinVersion == "" {
+		return "1.2"
+	}
+	return conf.MinVersion
+}
+
+func (conf *Config) findBan(userhost string) (BanConfig, bool) {
+	for _, ban := range conf.Bans {
+		if matchMask(ban.Mask, userhost) {
+			return ban, true
+		}
+	}
+	return BanConfig{}, false
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func (conf *Config) class(name string) ClassConfig {
+	// Looks up a connection class, falling back to the built-in default.
+	for _, class := range conf.Classes {
+		if class.Name == name {
+			return class
+		}
+	}
+	for _, class := range conf.Classes {
+		if class.Name == "default" {
+			return class
+		}
+	}
+	return ClassConfig{Name: "default"}
+}
+
+func (conf ServerConfig) caseMapping() string {
+	if conf.CaseMapping != "" {
+		return conf.CaseMapping
+	}
+	return "rfc1459"
+}
+
+func (class ClassConfig) sendQ() int {
+	if class.SendQ > 0 {
+		return class.SendQ
+	}
+	return 256 * 1024
+}
+
+func (class ClassConfig) pingFreq() time.Duration {
+	if class.PingFreq > 0 {
+		return time.Duration(class.PingFreq) * time.Second
+	}
+	return 2 * time.Minute
+}
+
+func (class ClassConfig) pingTimeout() time.Duration {
+	if class.PingTimeout > 0 {
+		return time.Duration(class.PingTimeout) * time.Second
+	}
+	return time.Minute
+}
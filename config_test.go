@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "ircd.json")
+}
+
+func Test_Load_Config(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"ircd.json": `{
+	"server": {"host": "irc.example.net", "network": "ExampleNet", "motd": "ircd.motd"},
+	"listeners": [{"address": ":6667", "class": "clients"}],
+	"classes": [{"name": "clients", "sendq": 1024}],
+	"limits": {"nicklen": 16}
+}`,
+		"ircd.motd": "Hello\nWorld\n",
+	})
+	conf, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server.Host != "irc.example.net" || conf.Limits.NickLen != 16 || conf.Limits.TopicLen != 390 {
+		t.Errorf("Unexpected config %+v", conf)
+	}
+	if len(conf.MOTD) != 2 || conf.MOTD[1] != "World" {
+		t.Errorf("MOTD not loaded: %q", conf.MOTD)
+	}
+	if class := conf.class("clients"); class.sendQ() != 1024 {
+		t.Errorf("Wrong class %+v", class)
+	}
+}
+
+func Test_Config_Errors(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{"{\n\"server\": {\n\"host\": \"nodots\"\n},\n\"listeners\": [{\"address\": \":6667\"}]\n}", "ircd.json:3: server.host"},
+		{"{\n\"listeners\": [\n{\"address\": \"nope\"}\n]\n}", "ircd.json:3: listeners[0].address"},
+		{"{\n\"server\": {\n\"casemapping\": \"utf8\"\n},\n\"listeners\": [{\"address\": \":1\"}]\n}", "ircd.json:3: server.casemapping"},
+		{"{\n\"listeners\": [{\"address\": \":1\"}],\n\"limits\": {\n\"nicklen\": \"nine\"\n}\n}", "ircd.json:4: "},
+		{"{\n\"listeners\": [{\"address\": \":1\"}],\n\n\"bogus\": 1\n}", "ircd.json:4: unknown setting bogus"},
+		{"{\n\"listeners\": [{\"address\": \":1\"}],\n\"sts\": {\"port\": 6697}\n}", "ircd.json:3: sts.duration"},
+		{"{\n\"server\": {\"password\": \"x\"},\n\"listeners\": [\n{\"address\": \":1\", \"password\": \"x\"}\n]\n}", "ircd.json:4: unknown setting listeners[0].password"},
+		{"{\n\"listeners\": [{\"address\": \":1\"}]\n,,\n}", "ircd.json:3: "},
+		{"{\n\"listeners\": [{\"address\": \":1\"}],\n\"opers\": [{\"name\": \"a\",\n\"Hosts\": [\"*@*\"], \"flags\": []}]\n}", "ircd.json:4: unknown setting opers[0].flags"},
+	}
+	for _, test := range tests {
+		_, err := loadConfig(writeConfig(t, map[string]string{"ircd.json": test.config}))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Got %v, want %q", err, test.want)
+		}
+	}
+}
+
+func Test_Rehash(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"ircd.json": `{"server": {"host": "irc.example.net", "motd": "ircd.motd"}, "listeners": [{"address": "127.0.0.1:0"}]}`,
+		"ircd.motd": "Old\n",
+	})
+	conf, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(conf)
+
+	// A broken file leaves the running config alone.
+	os.WriteFile(path, []byte(`{"listeners": [}`), 0600)
+	if _, err := server.rehash(); err == nil || server.Config != conf {
+		t.Error("Broken config was applied.")
+	}
+
+	os.WriteFile(filepath.Join(filepath.Dir(path), "ircd.motd"), []byte("New\n"), 0600)
+	os.WriteFile(path, []byte(`{
+		"server": {"host": "irc2.example.net", "motd": "ircd.motd"},
+		"listeners": [{"address": "127.0.0.1:0"}],
+		"limits": {"nicklen": 20},
+		"bans": [{"mask": "*@10.*", "reason": "No"}]
+	}`), 0600)
+	rejected, err := server.rehash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || server.Host != "irc.example.net" {
+		t.Errorf("Host change should be rejected, got %q", rejected)
+	}
+	if server.Config.MOTD[0] != "New" || server.Config.Limits.NickLen != 20 {
+		t.Errorf("Rehash didn't apply: %+v", server.Config)
+	}
+	if _, banned := server.Config.findBan("user@10.1.2.3"); !banned {
+		t.Error("Ban not loaded.")
+	}
+}
+
+func Test_Rehash_Accounts(t *testing.T) {
+	config := `{"listeners": [{"address": "127.0.0.1:0"}], "accounts": [{"name": "Syed", "password": "hunter2"}, {"name": "bot", "password": %q}]}`
+	path := writeConfig(t, map[string]string{"ircd.json": fmt.Sprintf(config, "old")})
+	conf, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(conf)
+	_, syed, _ := server.Accounts.ScramCredentials("syed")
+
+	os.WriteFile(path, []byte(fmt.Sprintf(config, "new")), 0600)
+	if _, err := server.rehash(); err != nil {
+		t.Fatal(err)
+	}
+	if _, creds, _ := server.Accounts.ScramCredentials("syed"); !bytes.Equal(creds.Salt, syed.Salt) {
+		t.Error("an unchanged account got new keys")
+	}
+	if _, ok := server.Accounts.CheckPassword("bot", "old"); ok {
+		t.Error("the old password still works")
+	}
+	select {
+	case msg := <-server.Messages:
+		msg.Event()
+	case <-time.After(5 * time.Second):
+		t.Fatal("new keys were never swapped in")
+	}
+	if _, ok := server.Accounts.CheckPassword("bot", "new"); !ok {
+		t.Error("the new password doesn't work")
+	}
+}
+
+func Test_Listeners(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "ircd.sock")
+	conf := defaultConfig()
+	conf.Listeners = []ListenerConfig{{Address: "127.0.0.1:0"}, {Network: "unix", Address: socket, Class: "bots"}}
+	conf.Classes = []ClassConfig{{Name: "bots", SendQ: 4096}}
+	server := newServer(conf)
+	go handleMessages(server.Messages)
+	if failed := server.openListeners(); len(failed) > 0 {
+		t.Fatal(failed)
+	}
+	if len(server.Listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d", len(server.Listeners))
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("NICK Bot\r\nUSER bot 0 * :Bot\r\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(line, " 001 ") {
+			if !strings.Contains(line, "Bot!~bot@localhost") {
+				t.Errorf("Unexpected welcome %q", line)
+			}
+			break
+		}
+	}
+
+	// Dropping a listener from the config closes it.
+	server.Messages <- ircMessage{Event: func() {
+		server.Config.Listeners = server.Config.Listeners[:1]
+		server.openListeners()
+	}}
+	server.Messages <- ircMessage{Event: func() {}} // Wait for the first event to finish.
+	if _, err := net.Dial("unix", socket); err == nil {
+		t.Error("Unix listener still open after removal.")
+	}
+}
@@ -1,26 +1,43 @@
 package main
 
 import (
+	"io"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func mock_user() (user ircUser) {
-	server := Server{}
-	server.Name = "TestIRCd"
-	server.Host = "TestIRCd.testserver.net"
-	server.Unregistered = make(map[*net.Conn]*ircUser)
-	server.Clients = make(map[string]*ircUser)
+	conf := defaultConfig()
+	conf.Server.Name = "TestIRCd"
+	conf.Server.Host = "TestIRCd.testserver.net"
+	server := newServer(conf)
 
 	user.Nick = "AUTH"
+	user.Channels = make(map[*Channel]bool)
 	user.Writer = make(chan string)
 	go mockWriter(user.Writer)
-	user.Conn, _ = net.Dial("tcp", "localhost:80") // Fake a net.Conn
-	user.Server = &server
+	user.Conn = mockConn() // Fake a net.Conn
+	user.Server = server
 	return user
 }
 
+func mockConn() net.Conn {
+	// Loopback connection so address lookups behave like a real client.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	go l.Accept()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	return conn
+}
+
 func mockWriter(writer <-chan string) {
 	for write := range writer {
 		write = write + "S" // Do nothing with it.
@@ -36,9 +53,8 @@ func mock_message(line string, user *ircUser) (msg ircMessage) {
 		msg.User, msg.Server = user, user.Server
 	}
 
-	lnsplit := strings.Split(string(line), " ")
-	lnsplit[1] = strings.TrimPrefix(lnsplit[1], ":") // Remove ":" prefix
-	msg.Command, msg.Payload = lnsplit[0], lnsplit[1:]
+	parsed, _ := parseMessage(line)
+	msg.Tags, msg.Source, msg.Command, msg.Params = parsed.Tags, parsed.Source, parsed.Command, parsed.Params
 	return
 }
 
@@ -71,7 +87,7 @@ func Test_Nick_Change(t *testing.T) {
 	// Change registered user's nick
 	nickmsg := mock_message("NICK Test2", reg2msg.User)
 	nickmsg.handleCommand()
-	if _, ok := nickmsg.Server.Clients[nickmsg.User.Nick]; ok && nickmsg.User.Nick == "Test2" {
+	if _, ok := nickmsg.Server.Clients[nickmsg.Server.casefold(nickmsg.User.Nick)]; ok && nickmsg.User.Nick == "Test2" {
 		t.Log("NICK Change Test has passed.")
 	} else {
 		t.Errorf("NICK Change Test has failed.")
@@ -109,5 +125,153 @@ func Test_Nick_Regex(t *testing.T) {
 }
 
 func Test_Mode_Regex(t *testing.T) {
-	// Will wrte tests here in the future.
+	user := mock_client(mock_user().Server, "Test")
+	send(user, "MODE test +wi-w+o+x")
+	lines := drain(user)
+	if len(lines) != 2 || !strings.Contains(lines[0], " 501 Test ") || lines[1] != ":Test MODE Test +i" {
+		t.Errorf("MODE gave %q", lines)
+	}
+	send(user, "MODE Test")
+	if lines := drain(user); len(lines) != 1 || !strings.HasSuffix(lines[0], " 221 Test +i") {
+		t.Errorf("mode query gave %q", lines)
+	}
+	send(user, "MODE Other +i")
+	if lines := drain(user); len(lines) != 1 || !strings.Contains(lines[0], " 502 ") {
+		t.Errorf("changing someone else gave %q", lines)
+	}
+}
+
+func Test_Writer_Flush(t *testing.T) {
+	// Everything queued before the writer is closed still goes out, in order.
+	conn, peer := net.Pipe()
+	user := &ircUser{Nick: "Test", Conn: conn, Writer: make(chan string)}
+	go user.writeLoop(1024)
+	go func() {
+		user.Writer <- "one"
+		user.Writer <- "two"
+		close(user.Writer)
+	}()
+	if data, _ := io.ReadAll(peer); string(data) != "one\r\ntwo\r\n" {
+		t.Errorf("peer read %q", data)
+	}
+}
+
+func Test_SendQ_Exceeded(t *testing.T) {
+	// Nobody reads the other end, so everything piles up in the queue.
+	conn, _ := net.Pipe()
+	user := &ircUser{Nick: "Test", Conn: conn, Writer: make(chan string)}
+	done := make(chan bool)
+	go func() {
+		user.writeLoop(100)
+		close(done)
+	}()
+	for range 10 {
+		user.Writer <- strings.Repeat("a", 20)
+	}
+	if atomic.LoadInt32(&user.sendqExceeded) != 1 {
+		t.Error("sendq wasn't marked as exceeded")
+	}
+	close(user.Writer)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("writer kept going after the sendq was exceeded")
+	}
+}
+
+func Test_Line_Framing(t *testing.T) {
+	long := "PRIVMSG #chan :" + strings.Repeat("a", 600)
+	tags := "@" + strings.Repeat("t", 9000) + " PING x"
+	input := "NICK Test\r\nUSER a 0 * :b\n" + long + "\r\n" + tags + "\r\nPING :still here\r\n"
+	reader := newLineReader(strings.NewReader(input))
+
+	want := []struct {
+		line string
+		err  error
+	}{
+		{"NICK Test", nil},
+		{"USER a 0 * :b", nil},
+		{"", errLineTooLong},
+		{"", errLineTooLong},
+		{"PING :still here", nil},
+	}
+	for i, w := range want {
+		line, err := reader.readLine()
+		if line != w.line || err != w.err {
+			t.Errorf("Line %d: got %q %v, want %q %v", i, line, err, w.line, w.err)
+		}
+	}
+	if _, err := reader.readLine(); err == nil {
+		t.Error("Expected EOF after the last line.")
+	}
+}
+
+func Test_Logged_Params(t *testing.T) {
+	user := mock_user()
+	for _, line := range []string{"PASS hunter2", "OPER botop hunter2", "AUTHENTICATE aHVudGVyMg=="} {
+		msg := mock_message(line, &user)
+		if got := msg.loggedParams(); strings.Contains(got, "hunter2") || strings.Contains(got, "aHVudGVyMg") {
+			t.Errorf("%q is logged as %s", line, got)
+		}
+	}
+	if msg := mock_message("JOIN #chan key", &user); msg.loggedParams() != "[#chan key]" {
+		t.Errorf("JOIN is logged as %s", msg.loggedParams())
+	}
+}
+
+func Test_Registration_Gating(t *testing.T) {
+	user := mock_user()
+	user.Writer = make(chan string, 100)
+
+	ison := mock_message("ISON Test", &user)
+	ison.handleCommand()
+	if reply := <-user.Writer; strings.Split(reply, " ")[1] != ERR_NOTREGISTERED {
+		t.Errorf("ISON before registration got %q", reply)
+	}
+
+	// CAP negotiation holds registration open until CAP END.
+	for _, line := range []string{"CAP LS 302", "NICK Test", "USER TestUser 0 * :..."} {
+		msg := mock_message(line, &user)
+		msg.handleCommand()
+	}
+	if user.isRegistered() {
+		t.Error("Registered before CAP END.")
+	}
+	end := mock_message("CAP END", &user)
+	end.handleCommand()
+	if !user.isRegistered() {
+		t.Error("Not registered after CAP END.")
+	}
+}
+
+func Test_Cap_Negotiation(t *testing.T) {
+	user := mock_user()
+	user.Writer = make(chan string, 100)
+	user.Server.Unregistered[&user.Conn] = &user
+	registerCap(capability{Name: "test-cap", Value: func(*ircUser) string { return "v1" }})
+	defer delete(capabilities, "test-cap")
+	user.Server.updateCaps()
+
+	replies := []struct{ line, want string }{
+		{"CAP LS 302", " test-cap=v1"},
+		{"CAP REQ :test-cap bogus", "CAP * NAK :test-cap bogus"},
+		{"CAP REQ :test-cap", "CAP * ACK test-cap"},
+		{"CAP LIST", "CAP * LIST :cap-notify test-cap"},
+		{"CAP REQ -cap-notify", "CAP * NAK -cap-notify"},
+		{"CAP FOO", "410 AUTH FOO :Invalid CAP command"},
+	}
+	for _, r := range replies {
+		msg := mock_message(r.line, &user)
+		msg.handleCommand()
+		if got := <-user.Writer; !strings.Contains(got, r.want) {
+			t.Errorf("%s: got %q, want %q", r.line, got, r.want)
+		}
+	}
+
+	// Removing a cap at runtime disables it and notifies the client.
+	delete(capabilities, "test-cap")
+	user.Server.updateCaps()
+	if got := <-user.Writer; !strings.HasSuffix(got, "CAP * DEL test-cap") || user.hasCap("test-cap") {
+		t.Errorf("Expected CAP DEL, got %q", got)
+	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Most clients only look at the first 13 tokens of each RPL_ISUPPORT line.
+const isupportPerLine = 13
+
+func (server *Server) isupport() []string {
+	limits := server.Config.Limits
+	return []string{
+		"CHANTYPES=#",
+		"NETWORK=" + server.Network,
+		"CASEMAPPING=" + server.Config.Server.caseMapping(),
+		"NICKLEN=" + strconv.Itoa(limits.NickLen),
+		"CHANNELLEN=" + strconv.Itoa(limits.ChannelLen),
+		"CHANLIMIT=#:" + strconv.Itoa(limits.ChanLimit),
+		"TOPICLEN=" + strconv.Itoa(limits.TopicLen),
+		"AWAYLEN=" + strconv.Itoa(limits.AwayLen),
+		"KICKLEN=" + strconv.Itoa(limits.KickLen),
+		"MODES=" + strconv.Itoa(limits.Modes),
+		"CHANMODES=" + channelModes.chanmodes(),
+		"PREFIX=" + channelModes.prefix(),
+		"MAXLIST=" + channelModes.letters(modeList) + ":" + strconv.Itoa(limits.MaxList),
+		"EXCEPTS=e",
+		"INVEX=I",
+		"EXTBAN=$," + extbanTypes,
+		"TARGMAX=PRIVMSG:" + strconv.Itoa(limits.MaxTargets) + ",NOTICE:" + strconv.Itoa(limits.MaxTargets) +
+			",KICK:" + strconv.Itoa(limits.MaxTargets) + ",JOIN:,PART:,NAMES:",
+		"KNOCK",
+		"ELIST=CMNTU",
+		"WHOX",
+		"MONITOR=" + strconv.Itoa(limits.Monitor),
+	}
+}
+
+func (user *ircUser) sendISupport() {
+	tokens := user.Server.isupport()
+	for len(tokens) > 0 {
+		n := min(len(tokens), isupportPerLine)
+		user.sendNumeric(RPL_ISUPPORT, strings.Join(tokens[:n], " "), ":are supported by this server")
+		tokens = tokens[n:]
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	maxLineLength = 512  // Message body including CRLF. RFC 1459 2.3
+	maxTagsLength = 8191 // Tag section including "@" and the trailing space. IRCv3 message-tags
+)
+
+var errLineTooLong = errors.New("input line too long")
+
+type lineReader struct {
+	r *bufio.Reader
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{bufio.NewReaderSize(r, maxTagsLength+maxLineLength)}
+}
+
+func (lr *lineReader) readLine() (string, error) {
+	// Reads one message, accepting both CRLF and bare LF endings.
+	// Oversized lines are consumed entirely and reported with errLineTooLong,
+	// so the caller can keep reading from the next line.
+	raw, err := lr.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		for err == bufio.ErrBufferFull {
+			_, err = lr.r.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r")
+	body := line
+	if strings.HasPrefix(line, "@") {
+		tags, rest, _ := strings.Cut(line, " ")
+		if len(tags)+1 > maxTagsLength {
+			return "", errLineTooLong
+		}
+		body = rest
+	}
+	if len(body)+2 > maxLineLength {
+		return "", errLineTooLong
+	}
+	return line, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type listRequest struct {
+	user     *ircUser
+	names    []string // Folded names of the channels still to send
+	masks    []string // The channel must match one of these, if there are any,
+	excludes []string // and none of these.
+	checks   []func(*Channel) bool
+}
+
+func IRC_LIST(msg *ircMessage) (string, string) {
+	// LIST [<filter>{,<filter>}]
+	// Filters (ELIST=CMNTU):
+	// >n, <n - more or fewer than n users;
+	// C>n, C<n - created more or less than n minutes ago;
+	// T>n, T<n - topic set more or less than n minutes ago;
+	// mask, !mask - channel name does or doesn't match the mask.
+	req := &listRequest{user: msg.User}
+	if len(msg.Params) > 0 {
+		for _, filter := range strings.Split(msg.Params[0], ",") {
+			req.addFilter(filter)
+		}
+	}
+	for name := range msg.Server.Channels {
+		req.names = append(req.names, name)
+	}
+	sort.Strings(req.names)
+
+	if msg.User.List != nil {
+		// Only one LIST at a time, so the old one ends where it got to.
+		msg.User.List = nil
+		msg.User.sendNumeric(RPL_LISTEND, ":End of /LIST")
+	}
+	msg.User.sendNumeric(RPL_LISTSTART, "Channel", ":Users  Name")
+	req.resume()
+	return "", ""
+}
+
+func (req *listRequest) addFilter(filter string) {
+	if filter == "" {
+		return
+	}
+	if strings.HasPrefix(filter, "!") {
+		req.excludes = append(req.excludes, filter[1:])
+		return
+	}
+	field := byte(0)
+	if len(filter) > 1 && (filter[0] == 'C' || filter[0] == 'T') && (filter[1] == '<' || filter[1] == '>') {
+		field, filter = filter[0], filter[1:]
+	}
+	if filter[0] != '<' && filter[0] != '>' {
+		req.masks = append(req.masks, filter)
+		return
+	}
+	n, err := strconv.Atoi(filter[1:])
+	if err != nil {
+		return // Not a filter we understand, so it doesn't filter anything.
+	}
+	less := filter[0] == '<'
+	req.checks = append(req.checks, func(channel *Channel) bool {
+		value := len(channel.Members)
+		switch field {
+		case 'C':
+			value = int(time.Since(channel.Created).Minutes())
+		case 'T':
+			if channel.TopicTime.IsZero() {
+				return false // Never had a topic, so it has no age.
+			}
+			value = int(time.Since(channel.TopicTime).Minutes())
+		}
+		if less {
+			return value < n
+		}
+		return value > n
+	})
+}
+
+func (req *listRequest) matches(channel *Channel) bool {
+	if !channel.visibleTo(req.user) {
+		return false
+	}
+	matched := len(req.masks) == 0
+	for _, mask := range req.masks {
+		matched = matched || matchMask(mask, channel.Name)
+	}
+	for _, mask := range req.excludes {
+		matched = matched && !matchMask(mask, channel.Name)
+	}
+	for _, check := range req.checks {
+		matched = matched && check(channel)
+	}
+	return matched
+}
+
+func (req *listRequest) resume() {
+	// Sends as much of the list as fits in half the client's sendq, then
+	// waits for the writer to say the client has read some of it.
+	user := req.user
+	if user.State == stateDisconnected {
+		return
+	}
+	limit := int64(user.Class.sendQ() / 2)
+	for len(req.names) > 0 {
+		if atomic.LoadInt64(&user.queued) > limit {
+			user.List = req
+			if user.waitForDrain(limit / 2) {
+				return
+			}
+			continue // Drained while we were setting up the wait.
+		}
+		channel, ok := user.Server.Channels[req.names[0]]
+		req.names = req.names[1:]
+		if ok && req.matches(channel) {
+			user.sendNumeric(RPL_LIST, channel.Name, strconv.Itoa(len(channel.Members)), ":"+channel.Topic)
+		}
+	}
+	user.List = nil
+	user.sendNumeric(RPL_LISTEND, ":End of /LIST")
+}
+
+func (user *ircUser) waitForDrain(below int64) bool {
+	// Asks the writer to call user.drained once the queue is down to below
+	// bytes. False if it already is, and nothing will be called.
+	atomic.StoreInt64(&user.drainBelow, below)
+	// The writer may have gone quiet before it saw drainBelow, so check again.
+	if atomic.LoadInt64(&user.queued) <= below && atomic.CompareAndSwapInt64(&user.drainBelow, below, 0) {
+		return false
+	}
+	return true
+}
+
+func (user *ircUser) checkDrained(queued int64) {
+	// Called by the writer as the queue changes, off the message goroutine.
+	below := atomic.LoadInt64(&user.drainBelow)
+	if below > 0 && queued <= below && atomic.CompareAndSwapInt64(&user.drainBelow, below, 0) {
+		// Not from the writer itself, the message goroutine may be waiting on it.
+		go func() {
+			user.Server.Messages <- ircMessage{User: user, Server: user.Server, Event: user.drained}
+		}()
+	}
+}
+
+func (user *ircUser) drained() {
+	if user.List != nil {
+		user.List.resume()
+	}
+}
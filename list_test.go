@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_List(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #busy,#quiet,#secret,#old")
+	send(bob, "JOIN #busy")
+	send(alice, "TOPIC #busy :come in")
+	send(alice, "MODE #secret +s")
+	channel, _ := server.findChannel("#old")
+	channel.Created = time.Now().Add(-time.Hour)
+	drain(alice)
+	drain(bob)
+
+	list := func(user *ircUser, line string) (names []string) {
+		send(user, line)
+		lines := drain(user)
+		if !strings.HasSuffix(lines[0], " 321 "+user.Nick+" Channel :Users  Name") ||
+			!strings.HasSuffix(lines[len(lines)-1], " 323 "+user.Nick+" :End of /LIST") {
+			t.Errorf("%s got %q", line, lines)
+		}
+		for _, line := range lines[1 : len(lines)-1] {
+			names = append(names, strings.SplitN(line, " ", 4)[3])
+		}
+		return
+	}
+	for line, want := range map[string]string{
+		"LIST":               "#busy 2 :come in|#old 1 :|#quiet 1 :",
+		"LIST >1":            "#busy 2 :come in",
+		"LIST <2,!#q*":       "#old 1 :",
+		"LIST #Q*,#busy":     "#busy 2 :come in|#quiet 1 :",
+		"LIST C>30":          "#old 1 :",
+		"LIST T<5":           "#busy 2 :come in",
+		"LIST T>5":           "",
+		"LIST #secret,>what": "",
+	} {
+		if got := strings.Join(list(bob, line), "|"); got != want {
+			t.Errorf("%s gave %q, want %q", line, got, want)
+		}
+	}
+	if got := strings.Join(list(alice, "LIST #secret"), "|"); got != "#secret 1 :" {
+		t.Errorf("members can't see their secret channel: %q", got)
+	}
+
+	// A client that isn't keeping up gets the rest once it catches up.
+	atomic.StoreInt64(&bob.queued, int64(bob.Class.sendQ()))
+	send(bob, "LIST")
+	if lines := drain(bob); len(lines) != 1 || bob.List == nil {
+		t.Errorf("LIST didn't wait for the client: %q", lines)
+	}
+	// Asking again ends the old one, rather than running both.
+	send(bob, "LIST")
+	if lines := drain(bob); len(lines) != 2 || !strings.Contains(lines[0], " 323 ") || !strings.Contains(lines[1], " 321 ") {
+		t.Errorf("a second LIST gave %q", lines)
+	}
+	atomic.StoreInt64(&bob.queued, 0)
+	bob.checkDrained(0)
+	select {
+	case msg := <-server.Messages:
+		msg.Event()
+	case <-time.After(time.Second):
+		t.Fatal("LIST never carried on")
+	}
+	if lines := drain(bob); len(lines) != 4 || !strings.Contains(lines[3], " 323 ") || bob.List != nil {
+		t.Errorf("the rest of the LIST was %q", lines)
+	}
+}
+
+func Test_List_Drain(t *testing.T) {
+	// The writer says when the client has caught up, if anyone is waiting.
+	server := mock_user().Server
+	conn, peer := net.Pipe()
+	go io.Copy(io.Discard, peer)
+	user := &ircUser{Nick: "Test", Server: server, Conn: conn, Writer: make(chan string)}
+	go user.writeLoop(1024)
+	if user.waitForDrain(10) {
+		t.Fatal("an idle writer looked busy")
+	}
+	atomic.StoreInt64(&user.drainBelow, 10)
+	user.write("PING x")
+	select {
+	case msg := <-server.Messages:
+		if msg.User != user || msg.Event == nil {
+			t.Errorf("writer sent %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("writer never said the queue drained")
+	}
+	close(user.Writer)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+)
+
+type listener struct {
+	Conf ListenerConfig
+	ln   net.Listener
+}
+
+func (l ListenerConfig) network() string {
+	if l.Network == "" {
+		return "tcp"
+	}
+	return l.Network
+}
+
+func (l ListenerConfig) key() string {
+	// Listeners are matched up across rehashes by everything that would need a new socket.
+	return fmt.Sprintf("%s/%s/%s/%t", l.network(), l.Address, l.Class, l.TLS)
+}
+
+func (server *Server) openListeners() (failed []string) {
+	// Brings the open sockets in line with server.Config. Removed listeners
+	// are closed first so a changed listener can take over the same address.
+	wanted := make(map[string]ListenerConfig)
+	for _, l := range server.Config.Listeners {
+		wanted[l.key()] = l
+	}
+	for key, l := range server.Listeners {
+		if _, ok := wanted[key]; !ok {
+			fmt.Println("Closing listener", l.Conf.network(), l.Conf.Address)
+			l.ln.Close()
+			delete(server.Listeners, key)
+		}
+	}
+
+	for i, conf := range server.Config.Listeners {
+		if _, ok := server.Listeners[conf.key()]; ok {
+			continue
+		}
+		if conf.network() == "unix" {
+			// Clear out a socket left behind by an earlier run.
+			if info, err := os.Stat(conf.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+				os.Remove(conf.Address)
+			}
+		}
+		ln, err := net.Listen(conf.network(), conf.Address)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("listeners[%d]: %v", i, err))
+			continue
+		}
+		if conf.TLS {
+			// Settings are looked up per handshake so REHASH can change them.
+			ln = tls.NewListener(ln, &tls.Config{GetConfigForClient: server.TLS.configForClient})
+		}
+		l := &listener{conf, ln}
+		server.Listeners[conf.key()] = l
+		if conf.TLS {
+			fmt.Println("Listening on", conf.network(), conf.Address, "(TLS)")
+		} else {
+			fmt.Println("Listening on", conf.network(), conf.Address)
+		}
+		go server.acceptLoop(l)
+	}
+	return
+}
+
+func (server *Server) acceptLoop(l *listener) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			fmt.Printf("%v\n - Listener %s stopped\n", err, l.Conf.Address)
+			return
+		}
+		fmt.Printf("%s: %v <-> %v\n", "New connection accepted", conn.LocalAddr(), conn.RemoteAddr())
+		// On connect, send connection info, message channel, and server
+		go handleConnection(conn, server.Messages, server, l)
+	}
+}
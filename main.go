@@ -1,187 +1,381 @@
 package main
 
 import (
-	"bufio"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 type Server struct {
-	Name         string
-	Host         string
+	Name         string // Description
+	Host         string // Server name
+	Network      string
+	Created      time.Time
+	Config       *Config
 	Unregistered map[*net.Conn]*ircUser
-	Clients      map[string]*ircUser
-	Connection   net.Listener
+	Clients      map[string]*ircUser          // Registered users, keyed by casefold(nick)
+	Nicks        map[string]*ircUser          // Every nick in use, registered or not, keyed by casefold(nick)
+	Channels     map[string]*Channel          // Keyed by casefold(name)
+	Whowas       map[string][]whowasEntry     // Past users, keyed by casefold(nick), oldest first
+	WhowasOrder  []whowasRef                  // Every Whowas entry, oldest first, for the server-wide limit
+	WhowasSeq    uint64                       // Seq of the newest Whowas entry
+	Monitors     map[string]map[*ircUser]bool // Who is MONITORing each casefold(nick)
+	Caps         map[string]bool              // Capabilities currently offered to clients
+	Accounts     AccountStore                 // Backend for SASL logins
+	Listeners    map[string]*listener         // Open sockets, keyed by ListenerConfig.key()
+	TLS          *tlsState                    // Shared by every TLS listener
+	Messages     chan ircMessage              // Everything that touches server state goes through here
 }
 
-func (server *Server) nickExists(nick string) (exists bool, registered bool, user ircUser) {
-	// Check if wanted nickname is in use. Case-insensitive
-	// Preferred usage: User-input when they may enter case insensitive nicks.
-	// BENCHMARK this, see if equalfold is resource intensive. -- Syed
-	if u, ok := server.Clients[nick]; ok { // Try the easy way first.
-		exists, registered, user = true, true, *u
+const ircdVersion = "goIRC-1.0.0"
+
+func newServer(conf *Config) *Server {
+	server := &Server{}
+	server.Created = time.Now()
+	server.Unregistered = make(map[*net.Conn]*ircUser)
+	server.Clients = make(map[string]*ircUser)
+	server.Nicks = make(map[string]*ircUser)
+	server.Channels = make(map[string]*Channel)
+	server.Whowas = make(map[string][]whowasEntry)
+	server.Monitors = make(map[string]map[*ircUser]bool)
+	server.Caps = make(map[string]bool)
+	server.Listeners = make(map[string]*listener)
+	server.TLS = &tlsState{}
+	server.Messages = make(chan ircMessage)
+	server.applyConfig(conf)
+	server.updateCaps()
+	return server
+}
+
+func (server *Server) applyConfig(conf *Config) {
+	old := server.Config
+	server.Config = conf
+	server.Name = conf.Server.Name
+	server.Host = conf.Server.Host
+	server.Network = conf.Server.Network
+	server.loadAccounts(old, conf)
+	server.TLS.apply(conf.TLS, conf.tlsCert)
+}
+
+func (server *Server) loadAccounts(old *Config, conf *Config) {
+	// Accounts whose password didn't change keep their keys, so a rehash doesn't
+	// redo PBKDF2 for everyone or break a SCRAM login halfway through. Keys for
+	// the rest are derived off the message goroutine, and those accounts work once they're done.
+	accounts := newMemoryAccounts()
+	var derive []AccountConfig
+	for _, account := range conf.Accounts {
+		if creds, ok := server.unchangedCreds(old, account); ok {
+			accounts.setAccount(account.Name, creds, account.CertFP)
+		} else {
+			derive = append(derive, account)
+		}
+	}
+	server.Accounts = accounts
+	if old == nil { // Starting up, so there's nobody to keep waiting.
+		for _, account := range derive {
+			accounts.addAccount(account.Name, account.Password, account.CertFP...)
+		}
 		return
 	}
-
-	for _, v := range server.Unregistered {
-		if strings.EqualFold(v.Nick, nick) {
-			exists, user = true, *v
-			return
+	if len(derive) == 0 {
+		return
+	}
+	go func() {
+		derived := newMemoryAccounts()
+		for _, account := range derive {
+			derived.addAccount(account.Name, account.Password, account.CertFP...)
 		}
+		server.Messages <- ircMessage{Server: server, Event: func() {
+			if server.Accounts != accounts {
+				return // Rehashed again since, and that took care of these.
+			}
+			for key, account := range derived.accounts {
+				accounts.accounts[key] = account
+			}
+		}}
+	}()
+}
+
+func (server *Server) unchangedCreds(old *Config, account AccountConfig) (scramCredentials, bool) {
+	current, ok := server.Accounts.(*memoryAccounts)
+	if !ok || old == nil {
+		return scramCredentials{}, false
 	}
-	for k, v := range server.Clients {
-		if strings.EqualFold(k, nick) {
-			exists, registered, user = true, true, *v
-			return
+	for _, previous := range old.Accounts {
+		if strings.EqualFold(previous.Name, account.Name) && previous.Password == account.Password {
+			if existing, ok := current.accounts[strings.ToLower(account.Name)]; ok {
+				return existing.Creds, true
+			}
 		}
 	}
+	return scramCredentials{}, false
+}
+
+func (server *Server) nickExists(nick string) (exists bool, registered bool, user *ircUser) {
+	// Check if wanted nickname is in use, in any case the casemapping treats as the same.
+	user, exists = server.Nicks[server.casefold(nick)]
+	if exists {
+		_, registered = server.Clients[server.casefold(nick)]
+	}
 	return
 }
 
-type ircMessage struct {
-	User    *ircUser
-	Command string
-	Payload []string
-	Server  *Server
+func (server *Server) findUser(nick string) (*ircUser, bool) {
+	// Registered users only, by case-insensitive nick.
+	user, ok := server.Clients[server.casefold(nick)]
+	return user, ok
 }
 
 func main() {
+	configPath := flag.String("config", "ircd.json", "path to the server configuration file")
+	flag.Parse()
+
 	// Check if root and if it is, send a warning.
 	if syscall.Geteuid() == 0 {
 		fmt.Println("WARNING: You're running as root, please don't do this if you can run as another user.")
 	}
 
-	// Initialize a new server
-	server := Server{}
-	server.Name = "Syed's FunHouse"
-	server.Host = "InitialIRCD.testserver.net"
-	server.Unregistered = make(map[*net.Conn]*ircUser)
-	server.Clients = make(map[string]*ircUser)
-
-	// Start listening on port 6667. More ports in the future.
-	conn, err := net.Listen("tcp", ":6667")
+	conf, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	server.Connection = conn
 
-	// Create a channel that handles messages for the server.
-	msgchan := make(chan ircMessage)
-	go handleMessages(msgchan)
+	// Initialize a new server
+	server := newServer(conf)
 
-	for {
-		conn, err := server.Connection.Accept()
-		if err != nil {
-			fmt.Printf("%v\n - Error is in main()", err)
-			break
-		}
-		fmt.Printf("%s: %v <-> %v\n", "New connection accepted", conn.LocalAddr(), conn.RemoteAddr())
-		// On connect, send connection info, message channel, and server
-		go handleConnection(conn, msgchan, &server)
+	// Handle messages for the server.
+	go handleMessages(server.Messages)
+	go server.checkPings()
+
+	// Start listening on every configured address.
+	if failed := server.openListeners(); len(failed) > 0 {
+		log.Fatal(strings.Join(failed, "\n"))
+	}
+	go server.TLS.watchCertificates()
+
+	// SIGHUP reloads the config, same as an operator's REHASH.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		server.Messages <- ircMessage{Server: server, Event: func() {
+			server.operNotice("Got SIGHUP, rehashing the server config file")
+			server.reportRehash(server.rehash())
+		}}
 	}
 }
 
-func handleConnection(c net.Conn, msgchan chan<- ircMessage, server *Server) {
-	raw := bufio.NewReader(c)
-	b := bufio.NewReader(io.LimitReader(raw, 1024))
+func handleConnection(c net.Conn, msgchan chan<- ircMessage, server *Server, l *listener) {
+	reader := newLineReader(c)
 
 	// Initialize User
 	user := ircUser{}
 	user.Nick = "AUTH"
 	user.Conn = c
 	user.Server = server
-
 	user.Writer = make(chan string)
-	killswitch := make(chan bool)
+	user.Channels = make(map[*Channel]bool)
 
-	// Start creating the message.
-	var message ircMessage
-	message.User, message.Server = &user, server
+	// Finish the TLS handshake up front, so the certificate fingerprint is
+	// known before the client can register or try SASL EXTERNAL.
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			fmt.Printf("TLS handshake with %v failed: %v\n", c.RemoteAddr(), err)
+			c.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		user.Secure = true
+		user.CertFP = certFingerprint(tlsConn)
+	}
 
-	go func() {
-		for {
-			select {
-			case <-killswitch:
-				fmt.Println("Killing writer goroutine for " + message.User.Nick)
-				return
-			default:
-				write := <-user.Writer
-				bytes := []byte(write + "\r\n")
-				_, err := user.Conn.Write(bytes)
-				if err != nil {
-					fmt.Printf("Write err: %v\n", err)
-					user.Conn.Close()
-					return
-				}
-			}
+	// The class comes from the live config, so finish setting up on the message goroutine.
+	msgchan <- ircMessage{User: &user, Server: server, Event: func() {
+		user.Class = server.Config.class(l.Conf.Class)
+		user.LastSeen = time.Now()
+		server.Unregistered[&user.Conn] = &user // So quiet connections still get pinged.
+		go user.writeLoop(user.Class.sendQ())
+		if user.CertFP != "" {
+			user.serverWrite(user.Nick, "NOTICE", "*** Your client certificate fingerprint is "+user.CertFP)
 		}
-	}()
+
+		// Send initial notices. In the future will actually check for hostname and ident
+		user.serverWrite(user.Nick, "NOTICE", "*** Looking up your hostname...")
+		user.serverWrite(user.Nick, "NOTICE", "*** Checking Ident")
+		user.serverWrite(user.Nick, "NOTICE", "*** Found your hostname")
+		user.serverWrite(user.Nick, "NOTICE", "*** No Ident response")
+	}}
 
 	for {
-		line, _, err := b.ReadLine()
+		line, err := reader.readLine()
+		if err == errLineTooLong {
+			msgchan <- ircMessage{User: &user, Server: server, Event: func() {
+				user.sendNumeric(ERR_INPUTTOOLONG, ":Input line was too long")
+			}}
+			continue
+		}
 		if err != nil { // EOF, or worse
 			fmt.Printf("%v\n", err)
-			killswitch <- true
-			c.Close()
+			reason := "Connection closed"
+			if atomic.LoadInt32(&user.sendqExceeded) == 1 {
+				reason = "SendQ exceeded"
+			} else if err != io.EOF {
+				reason = "Read error"
+			}
+			// The user is removed from the server on the message goroutine, like everything else.
+			msgchan <- ircMessage{User: &user, Server: server, Event: func() { user.quit(reason) }}
 			return
 		}
-		// Split the incoming message into command and payload and send to message channel
-		lnsplit := strings.Split(string(line), " ")
-		message.Command = strings.ToUpper(lnsplit[0]) // Commands are stored in uppercase
-		if len(lnsplit) > 1 {
-			lnsplit[1] = strings.TrimPrefix(lnsplit[1], ":") // Remove ":" prefix
-			message.Payload = lnsplit[1:]
+		// Parse the incoming line and send it to the message channel
+		message, err := parseMessage(line)
+		if err != nil {
+			continue // Blank lines are silently ignored.
 		}
+		message.User, message.Server = &user, server
 		msgchan <- message
-		// Remove payload and command from memory.
-		message.Payload, message.Command = []string{}, ""
 	}
 	// log.Printf("Connection from %v closed.", c.RemoteAddr())
 }
 
+func (user *ircUser) writeLoop(sendq int) {
+	// Queues everything sent on user.Writer so writers never wait on the
+	// socket. If more than sendq bytes pile up, the connection is dropped.
+	socket := make(chan string)
+	go func() {
+		for line := range socket {
+			if _, err := user.Conn.Write([]byte(line + "\r\n")); err != nil {
+				fmt.Printf("Write err: %v\n", err)
+				user.Conn.Close()
+			}
+		}
+		user.Conn.Close()
+	}()
+
+	var queue []string
+	queued := 0
+	writer := user.Writer
+	for writer != nil || len(queue) > 0 {
+		var out chan string
+		var next string
+		if len(queue) > 0 {
+			out, next = socket, queue[0]
+		}
+		select {
+		case line, ok := <-writer:
+			if !ok {
+				writer = nil // Flush what's left, then stop.
+				continue
+			}
+			if atomic.LoadInt32(&user.sendqExceeded) == 1 {
+				continue
+			}
+			queue = append(queue, line)
+			queued += len(line) + 2
+			if queued > sendq {
+				atomic.StoreInt32(&user.sendqExceeded, 1)
+				queue, queued = nil, 0
+				user.Conn.Close()
+			}
+		case out <- next:
+			queue = queue[1:]
+			queued -= len(next) + 2
+		}
+		atomic.StoreInt64(&user.queued, int64(queued))
+		user.checkDrained(int64(queued))
+	}
+	close(socket)
+}
+
 func handleMessages(msgchan <-chan ircMessage) {
 	for msg := range msgchan {
+		if msg.Event != nil {
+			msg.Event()
+			continue
+		}
+		if msg.User.State == stateDisconnected {
+			continue // Still queued when they left.
+		}
 		// Rate limiter
 		// if msg.User.reachedLimit() {
 		// 	msg.User.raw(":"+msg.User.Host, "QUIT", ":Excess flood")
 		// 	msg.User.Conn.Close()
 		// 	msg.User.deleteUser()
 		// }
-		fmt.Printf("%s :: %s || %s\n", msg.User.Nick, msg.Command, msg.Payload)
+		fmt.Printf("%s :: %s || %s\n", msg.User.Nick, msg.Command, msg.loggedParams())
 		msg.handleCommand()
 	}
 }
 
+func (msg *ircMessage) loggedParams() string {
+	// Passwords and SASL payloads stay out of the log.
+	switch msg.Command {
+	case "AUTHENTICATE", "PASS", "OPER":
+		return "[<hidden>]"
+	}
+	return fmt.Sprint(msg.Params)
+}
+
 type CommandInfo struct {
 	// Will put more here in future.
-	run     func(*ircMessage) (string, string) // Holds a pointer to function call
-	minimum int                                // Minimum parameters allowed
+	run          func(*ircMessage) (string, string) // Holds a pointer to function call
+	minimum      int                                // Minimum parameters allowed
+	unregistered bool                               // Usable before registration completes
 }
 
 func (msg *ircMessage) handleCommand() {
+	msg.User.LastSeen = time.Now()
+	msg.User.PingToken = "" // They're still there, whatever they sent.
+
 	// Call related function
 	// List of all handlers based on the scommand sent by clients.
 	commands := map[string]CommandInfo{
-		// Command : Function, minimum parameters.
-		"USER":     CommandInfo{IRC_USER, 4},
-		"NICK":     CommandInfo{IRC_NICK, 1},
-		"CAP":      CommandInfo{IRC_CAP, 0},
-		"QUIT":     CommandInfo{IRC_QUIT, 0},
-		"PING":     CommandInfo{IRC_PONG, 0},
-		"MODE":     CommandInfo{IRC_MODE, 1},
-		"USERHOST": CommandInfo{IRC_USERHOST, 1},
-		"ISON":     CommandInfo{IRC_ISON, 1},
-		"TIME":     CommandInfo{IRC_TIME, 0},
+		// Command : Function, minimum parameters, usable before registration.
+		"USER":         CommandInfo{IRC_USER, 4, true},
+		"NICK":         CommandInfo{IRC_NICK, 1, true},
+		"PASS":         CommandInfo{IRC_PASS, 1, true},
+		"CAP":          CommandInfo{IRC_CAP, 1, true},
+		"AUTHENTICATE": CommandInfo{IRC_AUTHENTICATE, 1, true},
+		"QUIT":         CommandInfo{IRC_QUIT, 0, true},
+		"PING":         CommandInfo{IRC_PING, 0, true},
+		"PONG":         CommandInfo{IRC_PONG, 0, true},
+		"MODE":         CommandInfo{IRC_MODE, 1, false},
+		"USERHOST":     CommandInfo{IRC_USERHOST, 1, false},
+		"ISON":         CommandInfo{IRC_ISON, 1, false},
+		"TIME":         CommandInfo{IRC_TIME, 0, false},
+		"OPER":         CommandInfo{IRC_OPER, 1, false},
+		"REHASH":       CommandInfo{IRC_REHASH, 0, false},
+		"JOIN":         CommandInfo{IRC_JOIN, 1, false},
+		"PART":         CommandInfo{IRC_PART, 1, false},
+		"NAMES":        CommandInfo{IRC_NAMES, 0, false},
+		"TOPIC":        CommandInfo{IRC_TOPIC, 1, false},
+		"PRIVMSG":      CommandInfo{IRC_PRIVMSG, 0, false},
+		"NOTICE":       CommandInfo{IRC_NOTICE, 0, false},
+		"KICK":         CommandInfo{IRC_KICK, 2, false},
+		"INVITE":       CommandInfo{IRC_INVITE, 2, false},
+		"KNOCK":        CommandInfo{IRC_KNOCK, 1, false},
+		"LIST":         CommandInfo{IRC_LIST, 0, false},
+		"AWAY":         CommandInfo{IRC_AWAY, 0, false},
+		"WHOIS":        CommandInfo{IRC_WHOIS, 1, false},
+		"WHOWAS":       CommandInfo{IRC_WHOWAS, 1, false},
+		"WHO":          CommandInfo{IRC_WHO, 1, false},
+		"MONITOR":      CommandInfo{IRC_MONITOR, 1, false},
 	}
 	if ircCommand, found := commands[msg.Command]; !found {
 		msg.User.sendNumeric(ERR_UNKNOWNCOMMAND, msg.Command+" :This command is unknown or unsupported.")
 		return
+	} else if !ircCommand.unregistered && !msg.User.isRegistered() {
+		msg.User.sendNumeric(ERR_NOTREGISTERED, ":You have not registered")
 	} else {
-		if len(msg.Payload) >= ircCommand.minimum {
+		if len(msg.Params) >= ircCommand.minimum {
 			retCode, retMsg := ircCommand.run(msg)
 			if retCode != "" && retMsg != "" {
 				msg.User.sendNumeric(retCode, retMsg)
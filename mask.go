@@ -0,0 +1,31 @@
+package main
+
+import "strings"
+
+func matchMask(pattern string, s string) bool {
+	// Case-insensitive glob match, "*" is any run of characters and "?" any one.
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			// Let the last star swallow one more character and retry.
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
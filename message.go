@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum number of parameters in a single message (RFC 1459 2.3).
+const maxParams = 15
+
+var errEmptyMessage = errors.New("empty message")
+
+type ircMessage struct {
+	User    *ircUser
+	Server  *Server
+	Tags    map[string]string // IRCv3 message tags, already unescaped.
+	Source  string            // Prefix without the leading ":"
+	Command string            // Always uppercase.
+	Params  []string          // Middle params, followed by the trailing param if one was sent.
+	Event   func()            // Set for work the server queues for itself, instead of a client command.
+}
+
+func parseMessage(line string) (msg ircMessage, err error) {
+	// [@tags] [:source] <command> [params] [:trailing]
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line = splitToken(line[1:])
+		msg.Tags = parseTags(tags)
+	}
+	line = strings.TrimLeft(line, " ")
+
+	if strings.HasPrefix(line, ":") {
+		msg.Source, line = splitToken(line[1:])
+	}
+
+	msg.Command, line = splitToken(line)
+	if msg.Command == "" {
+		return msg, errEmptyMessage
+	}
+	msg.Command = strings.ToUpper(msg.Command) // Commands are stored in uppercase
+
+	for line != "" {
+		// The last parameter swallows the rest of the line, spaces included.
+		if strings.HasPrefix(line, ":") || len(msg.Params) == maxParams-1 {
+			msg.Params = append(msg.Params, strings.TrimPrefix(line, ":"))
+			break
+		}
+		var param string
+		param, line = splitToken(line)
+		msg.Params = append(msg.Params, param)
+	}
+	return
+}
+
+func splitToken(s string) (token string, rest string) {
+	// Returns everything up to the first space, and the remainder with
+	// any run of separating spaces removed.
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], strings.TrimLeft(s[i+1:], " ")
+	}
+	return s, ""
+}
+
+func truncate(s string, limit int) string {
+	// Cuts s to at most limit bytes without splitting a UTF-8 sequence.
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+func packLines(prefix string, sep string, items []string) []string {
+	// Joins items with sep over as few lines as it takes for each to fit
+	// in one message after prefix. No items gives no lines.
+	budget := maxLineLength - 2 - len(prefix)
+	var lines []string
+	line := ""
+	for _, item := range items {
+		if line != "" && len(line)+len(sep)+len(item) > budget {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += sep
+		}
+		line += item
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = unescapeTagValue(value)
+	}
+	return tags
+}
+
+var (
+	tagEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+	tagUnescaper = strings.NewReplacer(`\\`, `\`, `\:`, ";", `\s`, " ", `\r`, "\r", `\n`, "\n")
+)
+
+func unescapeTagValue(value string) string {
+	// A trailing lone backslash is dropped, as the spec requires.
+	value = strings.TrimSuffix(value, `\`)
+	return tagUnescaper.Replace(value)
+}
+
+func (msg *ircMessage) String() string {
+	// Serializes the message back into a single line, without the CRLF.
+	var b strings.Builder
+	if len(msg.Tags) > 0 {
+		keys := make([]string, 0, len(msg.Tags))
+		for k := range msg.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // Keep output stable.
+
+		b.WriteByte('@')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(k)
+			if v := msg.Tags[k]; v != "" {
+				b.WriteByte('=')
+				b.WriteString(tagEscaper.Replace(v))
+			}
+		}
+		b.WriteByte(' ')
+	}
+	if msg.Source != "" {
+		b.WriteByte(':')
+		b.WriteString(msg.Source)
+		b.WriteByte(' ')
+	}
+	b.WriteString(msg.Command)
+	for i, param := range msg.Params {
+		b.WriteByte(' ')
+		// Only the last parameter may be empty, contain spaces, or start with ":".
+		if i == len(msg.Params)-1 && (param == "" || strings.Contains(param, " ") || param[0] == ':') {
+			b.WriteByte(':')
+		}
+		b.WriteString(param)
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Parse_Message(t *testing.T) {
+	tests := []struct {
+		line    string
+		tags    map[string]string
+		source  string
+		command string
+		params  []string
+	}{
+		{"USER x 0 * :Real Name Here", nil, "", "USER", []string{"x", "0", "*", "Real Name Here"}},
+		{":nick!u@h privmsg  #chan   :hi  there", nil, "nick!u@h", "PRIVMSG", []string{"#chan", "hi  there"}},
+		{"@id=1;+draft/x=a\\sb\\:c;flag PING :", map[string]string{"id": "1", "+draft/x": "a b;c", "flag": ""}, "", "PING", []string{""}},
+		{"ISON a b c\r\n", nil, "", "ISON", []string{"a", "b", "c"}},
+		{"CMD 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16", nil, "", "CMD",
+			[]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15 16"}},
+	}
+	for _, test := range tests {
+		msg, err := parseMessage(test.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg.Tags, test.tags) && (len(msg.Tags) != 0 || len(test.tags) != 0) {
+			t.Errorf("%q: tags %v, want %v", test.line, msg.Tags, test.tags)
+		}
+		if msg.Source != test.source || msg.Command != test.command || !reflect.DeepEqual(msg.Params, test.params) {
+			t.Errorf("%q: got %q %q %q", test.line, msg.Source, msg.Command, msg.Params)
+		}
+	}
+
+	if _, err := parseMessage("   "); err != errEmptyMessage {
+		t.Error("Blank line should not parse.")
+	}
+}
+
+func Test_Serialize_Message(t *testing.T) {
+	msg := ircMessage{
+		Tags:    map[string]string{"time": "now", "msg": "a b;c"},
+		Source:  "srv",
+		Command: "NOTICE",
+		Params:  []string{"nick", "hello world"},
+	}
+	want := `@msg=a\sb\:c;time=now :srv NOTICE nick :hello world`
+	if got := msg.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Round trip.
+	parsed, _ := parseMessage(want)
+	if parsed.String() != want {
+		t.Errorf("Round trip gave %q", parsed.String())
+	}
+
+	msg = ircMessage{Command: "MODE", Params: []string{"nick", "+i"}}
+	if got := msg.String(); got != "MODE nick +i" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func Test_Truncate(t *testing.T) {
+	tests := []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本", 2, ""},
+	}
+	for _, test := range tests {
+		if got := truncate(test.in, test.limit); got != test.want {
+			t.Errorf("truncate(%q, %d) gave %q, want %q", test.in, test.limit, got, test.want)
+		}
+	}
+}
+
+func Test_Pack_Lines(t *testing.T) {
+	if lines := packLines("x", " ", nil); len(lines) != 0 {
+		t.Errorf("no items gave %q", lines)
+	}
+	prefix := strings.Repeat("x", maxLineLength-2-10)
+	lines := packLines(prefix, ",", []string{"aaaa", "bbbb", "cccc", "dddddddddd"})
+	if len(lines) != 3 || lines[0] != "aaaa,bbbb" || lines[1] != "cccc" || lines[2] != "dddddddddd" {
+		t.Errorf("packing gave %q", lines)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import "strings"
+
+type modeType int
+
+// Mode types, numbered in the order CHANMODES lists them.
+const (
+	modeList   modeType = iota // A: a list, the parameter is always sent (+b)
+	modeAlways                 // B: takes a parameter whether it's set or unset (+k)
+	modeOnSet                  // C: takes a parameter only when it's set (+l)
+	modeFlag                   // D: never takes a parameter (+n)
+	modePrefix                 // Gives a member a prefix, the parameter is their nick (+o)
+)
+
+type modeDef struct {
+	Char   byte
+	Type   modeType
+	Prefix byte // Shown before the member's nick, modePrefix only.
+	SetBy  byte // Lowest prefix mode that can give or take it, modePrefix only.
+}
+
+// Every mode a target knows about. Prefix modes are listed highest rank first.
+type modeTable []modeDef
+
+type modeChange struct {
+	Adding bool
+	Mode   byte
+	Param  string
+}
+
+var userModes = modeTable{
+	{Char: 'a', Type: modeFlag}, // Away, only AWAY sets it
+	{Char: 'i', Type: modeFlag}, // Invisible
+	{Char: 'o', Type: modeFlag}, // IRC operator, only OPER sets it
+	{Char: 'w', Type: modeFlag}, // Receives wallops
+}
+
+var channelModes = modeTable{
+	{Char: 'b', Type: modeList},                            // Bans
+	{Char: 'e', Type: modeList},                            // Ban exceptions
+	{Char: 'I', Type: modeList},                            // Invite exceptions, may join while +i
+	{Char: 'k', Type: modeAlways},                          // Key needed to join
+	{Char: 'l', Type: modeOnSet},                           // Member limit
+	{Char: 'i', Type: modeFlag},                            // Invite only
+	{Char: 'm', Type: modeFlag},                            // Moderated
+	{Char: 'n', Type: modeFlag},                            // No messages from outside
+	{Char: 'p', Type: modeFlag},                            // Private
+	{Char: 's', Type: modeFlag},                            // Secret
+	{Char: 't', Type: modeFlag},                            // Only ops change the topic
+	{Char: 'q', Type: modePrefix, Prefix: '~', SetBy: 'q'}, // Founder
+	{Char: 'a', Type: modePrefix, Prefix: '&', SetBy: 'q'}, // Protected, ops can't take their modes
+	{Char: 'o', Type: modePrefix, Prefix: '@', SetBy: 'o'}, // Operator
+	{Char: 'h', Type: modePrefix, Prefix: '%', SetBy: 'o'}, // Halfop
+	{Char: 'v', Type: modePrefix, Prefix: '+', SetBy: 'h'}, // Voice
+}
+
+func (table modeTable) find(mode byte) (modeDef, bool) {
+	for _, def := range table {
+		if def.Char == mode {
+			return def, true
+		}
+	}
+	return modeDef{}, false
+}
+
+func (table modeTable) rank(mode byte) int {
+	// Where a prefix mode ranks, higher is more privileged. 0 for anything else.
+	prefixes := table.letters(modePrefix)
+	if i := strings.IndexByte(prefixes, mode); i >= 0 {
+		return len(prefixes) - i
+	}
+	return 0
+}
+
+func (def modeDef) takesParam(adding bool) bool {
+	switch def.Type {
+	case modeFlag:
+		return false
+	case modeOnSet:
+		return adding
+	}
+	return true
+}
+
+func (table modeTable) parse(modestring string, params []string, limit int) (changes []modeChange, unknown []byte) {
+	// Reads "+nt-k+l key 10" into single changes. Changes that need a parameter
+	// past the first limit of them are dropped, along with their parameter.
+	adding, withParam := true, 0
+	for i := 0; i < len(modestring); i++ {
+		char := modestring[i]
+		if char == '+' || char == '-' {
+			adding = char == '+'
+			continue
+		}
+		def, ok := table.find(char)
+		if !ok {
+			unknown = append(unknown, char)
+			continue
+		}
+		change := modeChange{Adding: adding, Mode: char}
+		if def.takesParam(adding) {
+			if len(params) == 0 {
+				if def.Type == modeList {
+					changes = append(changes, change) // No mask means show the list.
+				}
+				continue
+			}
+			change.Param, params = params[0], params[1:]
+			if withParam++; withParam > limit {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	return
+}
+
+func (table modeTable) collapse(changes []modeChange, fold func(string) string, redundant func(modeChange) bool) []modeChange {
+	// Only the last change to each mode counts (to each mode and parameter,
+	// for lists and prefixes), and changes that wouldn't do anything go.
+	latest := make(map[string]int)
+	var merged []modeChange
+	for _, change := range changes {
+		key := string(change.Mode)
+		if def, _ := table.find(change.Mode); def.Type == modeList || def.Type == modePrefix {
+			key += " " + fold(change.Param)
+		}
+		if i, ok := latest[key]; ok {
+			merged[i] = change
+			continue
+		}
+		latest[key] = len(merged)
+		merged = append(merged, change)
+	}
+
+	kept := merged[:0]
+	for _, change := range merged {
+		if !redundant(change) {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+func formatModes(changes []modeChange) []string {
+	// "+nt-k+l" followed by the parameters in the same order.
+	var modes strings.Builder
+	var params []string
+	sign := byte(0)
+	for _, change := range changes {
+		next := byte('-')
+		if change.Adding {
+			next = '+'
+		}
+		if next != sign {
+			modes.WriteByte(next)
+			sign = next
+		}
+		modes.WriteByte(change.Mode)
+		if change.Param != "" {
+			params = append(params, change.Param)
+		}
+	}
+	return append([]string{modes.String()}, params...)
+}
+
+func (table modeTable) letters(types ...modeType) string {
+	// Every mode of the given types, or of any type if none are given.
+	var letters strings.Builder
+	for _, def := range table {
+		for _, t := range types {
+			if def.Type == t {
+				letters.WriteByte(def.Char)
+				break
+			}
+		}
+		if len(types) == 0 {
+			letters.WriteByte(def.Char)
+		}
+	}
+	return letters.String()
+}
+
+func (table modeTable) chanmodes() string {
+	// The CHANMODES=A,B,C,D token.
+	return table.letters(modeList) + "," + table.letters(modeAlways) + "," +
+		table.letters(modeOnSet) + "," + table.letters(modeFlag)
+}
+
+func (table modeTable) prefix() string {
+	// The PREFIX=(modes)prefixes token, empty if there are no prefix modes.
+	modes, prefixes := "", ""
+	for _, def := range table {
+		if def.Type == modePrefix {
+			modes += string(def.Char)
+			prefixes += string(def.Prefix)
+		}
+	}
+	if modes == "" {
+		return ""
+	}
+	return "(" + modes + ")" + prefixes
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Mode_Engine(t *testing.T) {
+	table := modeTable{
+		{Char: 'b', Type: modeList},
+		{Char: 'k', Type: modeAlways},
+		{Char: 'l', Type: modeOnSet},
+		{Char: 'n', Type: modeFlag},
+		{Char: 'o', Type: modePrefix, Prefix: '@'},
+	}
+	if got := table.chanmodes(); got != "b,k,l,n" {
+		t.Errorf("CHANMODES=%s", got)
+	}
+	if got := table.prefix(); got != "(o)@" {
+		t.Errorf("PREFIX=%s", got)
+	}
+
+	changes, unknown := table.parse("+nxl-l+kob", []string{"10", "key", "nick", "mask"}, 3)
+	want := []modeChange{{true, 'n', ""}, {true, 'l', "10"}, {false, 'l', ""}, {true, 'k', "key"}, {true, 'o', "nick"}}
+	if !reflect.DeepEqual(changes, want) || string(unknown) != "x" {
+		t.Errorf("parse gave %v, unknown %q", changes, unknown)
+	}
+
+	set := map[byte]bool{'n': true}
+	changes = table.collapse(changes, strings.ToLower, func(change modeChange) bool { return change.Adding == set[change.Mode] })
+	if got := strings.Join(formatModes(changes), " "); got != "+ko key nick" {
+		t.Errorf("collapsed to %q", got)
+	}
+
+	changes, _ = table.parse("-o+ob", []string{"Nick", "nick"}, 3)
+	if got := strings.Join(formatModes(table.collapse(changes, strings.ToLower, func(modeChange) bool { return false })), " "); got != "+ob nick" {
+		t.Errorf("list query and prefix collapse gave %q", got)
+	}
+}
+
+func Test_Channel_Mode(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #test")
+	send(bob, "JOIN #test")
+	drain(alice)
+	drain(bob)
+
+	send(alice, "MODE #test +mil-m+kz 0 secret")
+	lines := drain(alice)
+	if len(lines) != 2 || !strings.Contains(lines[0], " 472 alice z ") ||
+		lines[1] != ":alice!~alice@127.0.0.1 MODE #test +ik secret" {
+		t.Errorf("alice got %q", lines)
+	}
+	if lines := drain(bob); len(lines) != 1 || lines[0] != ":alice!~alice@127.0.0.1 MODE #test +ik secret" {
+		t.Errorf("bob got %q", lines)
+	}
+	send(bob, "MODE #test -k secret")
+	if lines := drain(bob); len(lines) != 1 || !strings.Contains(lines[0], " 482 bob #test ") {
+		t.Errorf("bob changing modes got %q", lines)
+	}
+
+	send(bob, "MODE #test")
+	lines = drain(bob)
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " 324 bob #test +kint secret") || !strings.Contains(lines[1], " 329 bob #test ") {
+		t.Errorf("members see %q", lines)
+	}
+	carol := mock_client(server, "carol")
+	send(carol, "MODE #test")
+	if lines := drain(carol); len(lines) != 2 || !strings.HasSuffix(lines[0], " 324 carol #test +kint *") {
+		t.Errorf("outsiders see %q", lines)
+	}
+
+	send(alice, "MODE #test +s")
+	drain(alice)
+	send(carol, "MODE #test")
+	if lines := drain(carol); len(lines) != 1 || !strings.Contains(lines[0], " 442 carol #test ") {
+		t.Errorf("outsiders of a secret channel see %q", lines)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	// Watchers get away, account and host changes through notifyChange,
+	// on top of whichever of away-notify, account-notify and chghost they asked for.
+	registerCap(capability{Name: "extended-monitor"})
+	registerCap(capability{Name: "chghost"})
+}
+
+func IRC_MONITOR(msg *ircMessage) (string, string) {
+	// MONITOR + <nick>{,<nick>}
+	// MONITOR - <nick>{,<nick>}
+	// MONITOR C - clear the list; L - show it; S - show who on it is online.
+	user := msg.User
+	targets := []string{}
+	if len(msg.Params) > 1 {
+		for _, nick := range strings.Split(msg.Params[1], ",") {
+			if nick != "" {
+				targets = append(targets, nick)
+			}
+		}
+	}
+
+	switch strings.ToUpper(msg.Params[0]) {
+	case "+":
+		if len(msg.Params) < 2 {
+			return ERR_NEEDMOREPARAMS, "MONITOR :Not enough parameters"
+		}
+		var added []string
+		for i, nick := range targets {
+			if _, ok := user.Monitoring[user.Server.casefold(nick)]; ok {
+				continue
+			}
+			if limit := msg.Server.Config.Limits.Monitor; len(user.Monitoring) >= limit {
+				user.sendNumeric(ERR_MONLISTFULL, strconv.Itoa(limit), strings.Join(targets[i:], ","), ":Monitor list is full.")
+				break
+			}
+			user.addMonitor(nick)
+			added = append(added, nick)
+		}
+		user.sendMonitorStatus(added)
+	case "-":
+		if len(msg.Params) < 2 {
+			return ERR_NEEDMOREPARAMS, "MONITOR :Not enough parameters"
+		}
+		for _, nick := range targets {
+			user.removeMonitor(nick)
+		}
+	case "C":
+		user.clearMonitors()
+	case "L":
+		user.sendNickList(RPL_MONLIST, user.monitorList())
+		return RPL_ENDOFMONLIST, ":End of MONITOR list"
+	case "S":
+		user.sendMonitorStatus(user.monitorList())
+	}
+	return "", ""
+}
+
+func (user *ircUser) addMonitor(nick string) {
+	key := user.Server.casefold(nick)
+	if user.Monitoring == nil {
+		user.Monitoring = make(map[string]string)
+	}
+	user.Monitoring[key] = nick
+	if user.Server.Monitors[key] == nil {
+		user.Server.Monitors[key] = make(map[*ircUser]bool)
+	}
+	user.Server.Monitors[key][user] = true
+}
+
+func (user *ircUser) removeMonitor(nick string) {
+	key := user.Server.casefold(nick)
+	delete(user.Monitoring, key)
+	delete(user.Server.Monitors[key], user)
+	if len(user.Server.Monitors[key]) == 0 {
+		delete(user.Server.Monitors, key)
+	}
+}
+
+func (user *ircUser) clearMonitors() {
+	for key := range user.Monitoring {
+		user.removeMonitor(key)
+	}
+}
+
+func (user *ircUser) monitorList() (nicks []string) {
+	for _, nick := range user.Monitoring {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return
+}
+
+func (user *ircUser) sendMonitorStatus(nicks []string) {
+	var online, offline []string
+	for _, nick := range nicks {
+		if target, ok := user.Server.findUser(nick); ok {
+			online = append(online, target.Host)
+		} else {
+			offline = append(offline, nick)
+		}
+	}
+	user.sendNickList(RPL_MONONLINE, online)
+	user.sendNickList(RPL_MONOFFLINE, offline)
+}
+
+func (user *ircUser) sendNickList(numeric string, nicks []string) {
+	// Comma separated, over as many lines as it takes.
+	prefix := ":" + user.Server.Host + " " + numeric + " " + user.Nick + " :"
+	for _, line := range packLines(prefix, ",", nicks) {
+		user.sendNumeric(numeric, ":"+line)
+	}
+}
+
+func (user *ircUser) notifyChange(capName string, command string, params ...string) {
+	// Sends a change to user (away, account, host) to channel peers who asked
+	// for capName, and to MONITOR watchers who asked for extended-monitor too.
+	peers := user.peers()
+	for watcher := range user.Server.Monitors[user.Server.casefold(user.Nick)] {
+		if watcher.hasCap("extended-monitor") {
+			peers[watcher] = true
+		}
+	}
+	for peer := range peers {
+		if peer.hasCap(capName) {
+			peer.sendMessage(user.Host, command, params...)
+		}
+	}
+}
+
+func (user *ircUser) notifyHostChange(username string, host string) {
+	// Nothing changes a user's username or host after registration yet. Whatever
+	// does should call this first, while user.Host is still the old mask.
+	user.notifyChange("chghost", "CHGHOST", username, host)
+}
+
+func (server *Server) notifyMonitors(user *ircUser, online bool) {
+	// Called as user's nick comes into use, or goes out of it.
+	for watcher := range server.Monitors[server.casefold(user.Nick)] {
+		if online {
+			watcher.sendNumeric(RPL_MONONLINE, ":"+user.Host)
+		} else {
+			watcher.sendNumeric(RPL_MONOFFLINE, ":"+user.Nick)
+		}
+	}
+}
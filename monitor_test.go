@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func Test_Monitor(t *testing.T) {
+	server := mock_user().Server
+	server.Config.Limits.Monitor = 3
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	alice.Caps = map[string]bool{"away-notify": true, "extended-monitor": true}
+
+	send(alice, "MONITOR + Bob,carol,dave,erin,frank")
+	lines := drain(alice)
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " 734 alice 3 erin,frank :Monitor list is full.") ||
+		!strings.HasSuffix(lines[1], " 730 alice :bob!~bob@127.0.0.1") || !strings.HasSuffix(lines[2], " 731 alice :carol,dave") {
+		t.Errorf("MONITOR + gave %q", lines)
+	}
+
+	carol := mock_client(server, "carol")
+	send(carol, "NICK erin")
+	send(bob, "AWAY :brb")
+	send(bob, "QUIT")
+	lines = drain(alice)
+	want := []string{
+		":TestIRCd.testserver.net 731 alice :carol",
+		":bob!~bob@127.0.0.1 AWAY brb",
+		":TestIRCd.testserver.net 731 alice :bob",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("alice got %q", lines)
+	}
+	if _, ok := server.Monitors["bob"]; !ok {
+		t.Error("quitting dropped someone else's MONITOR entry")
+	}
+
+	send(alice, "MONITOR - dave")
+	send(alice, "MONITOR L")
+	send(alice, "MONITOR S")
+	lines = drain(alice)
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], " 732 alice :Bob,carol") || !strings.HasSuffix(lines[1], " 733 alice :End of MONITOR list") ||
+		!strings.HasSuffix(lines[2], " 731 alice :Bob,carol") {
+		t.Errorf("listing gave %q", lines)
+	}
+
+	send(alice, "MONITOR C")
+	send(alice, "QUIT")
+	if len(server.Monitors) != 0 {
+		t.Errorf("MONITOR entries left behind: %v", server.Monitors)
+	}
+}
+
+func Test_Extended_Monitor(t *testing.T) {
+	server := mock_user().Server
+	server.Accounts.(*memoryAccounts).addAccount("bob", "hunter2")
+	alice, bob, carol, dave := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol"), mock_client(server, "dave")
+	alice.Caps = map[string]bool{"extended-monitor": true, "account-notify": true, "chghost": true}
+	bob.Caps = map[string]bool{"sasl": true}
+	carol.Caps = map[string]bool{"account-notify": true}
+	dave.Caps = map[string]bool{"account-notify": true}
+	send(alice, "MONITOR + bob")
+	send(dave, "MONITOR + bob")
+	send(bob, "JOIN #test")
+	send(carol, "JOIN #test")
+	for _, user := range []*ircUser{alice, bob, carol, dave} {
+		drain(user)
+	}
+
+	send(bob, "AUTHENTICATE PLAIN")
+	send(bob, "AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte("\x00bob\x00hunter2")))
+	(<-server.Messages).Event() // The password check.
+	for _, user := range []*ircUser{alice, carol} {
+		if lines := drain(user); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 ACCOUNT bob" {
+			t.Errorf("%s got %q", user.Nick, lines)
+		}
+	}
+	if lines := drain(dave); len(lines) != 0 {
+		t.Errorf("dave didn't ask for extended-monitor but got %q", lines)
+	}
+
+	bob.notifyHostChange("~bob", "example.net")
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 CHGHOST ~bob example.net" {
+		t.Errorf("alice got %q", lines)
+	}
+	if lines := drain(carol); len(lines) != 0 {
+		t.Errorf("carol didn't ask for chghost but got %q", lines)
+	}
+}
@@ -34,6 +34,7 @@ const (
 
 	RPL_LOCALUSERS  = "265"
 	RPL_GLOBALUSERS = "266"
+	RPL_WHOISCERTFP = "276" // not RFC, from oftc/charybdis
 
 	RPL_AWAY = "301"
 
@@ -43,11 +44,16 @@ const (
 	RPL_RULESTART = "308" // unrealircd
 	RPL_RULESEND  = "309" // unrealircd
 
-	RPL_WHOISSERVER = "312"
-	RPL_WHOWASUSER  = "314"
+	RPL_WHOISUSER     = "311"
+	RPL_WHOISSERVER   = "312"
+	RPL_WHOISOPERATOR = "313"
+	RPL_WHOWASUSER    = "314"
 
-	RPL_ENDOFWHO   = "315"
-	RPL_ENDOFWHOIS = "318"
+	RPL_ENDOFWHO      = "315"
+	RPL_WHOISIDLE     = "317"
+	RPL_ENDOFWHOIS    = "318"
+	RPL_WHOISCHANNELS = "319"
+	RPL_WHOISACCOUNT  = "330"
 
 	RPL_LISTSTART = "321"
 	RPL_LIST      = "322"
@@ -59,13 +65,21 @@ const (
 	RPL_TOPIC          = "332"
 	RPL_TOPICTIME      = "333" // not RFC, extremely common though
 
-	RPL_INVITING    = "341"
-	RPL_VERSION     = "351"
-	RPL_NAMREPLY    = "353"
-	RPL_LINKS       = "364"
-	RPL_ENDOFLINKS  = "365"
-	RPL_ENDOFNAMES  = "366"
-	RPL_ENDOFWHOWAS = "369"
+	RPL_INVITING        = "341"
+	RPL_INVITELIST      = "346"
+	RPL_ENDOFINVITELIST = "347"
+	RPL_EXCEPTLIST      = "348"
+	RPL_ENDOFEXCEPTLIST = "349"
+	RPL_VERSION         = "351"
+	RPL_WHOREPLY        = "352"
+	RPL_NAMREPLY        = "353"
+	RPL_WHOSPCRPL       = "354" // WHOX
+	RPL_LINKS           = "364"
+	RPL_ENDOFLINKS      = "365"
+	RPL_ENDOFNAMES      = "366"
+	RPL_BANLIST         = "367"
+	RPL_ENDOFBANLIST    = "368"
+	RPL_ENDOFWHOWAS     = "369"
 
 	RPL_INFO      = "371"
 	RPL_ENDOFINFO = "374"
@@ -73,7 +87,8 @@ const (
 	RPL_MOTDSTART = "375"
 	RPL_ENDOFMOTD = "376"
 
-	RPL_WHOWASIP = "379"
+	RPL_WHOISHOST = "378"
+	RPL_WHOWASIP  = "379"
 
 	RPL_YOUAREOPER        = "381"
 	RPL_REHASHING         = "382"
@@ -89,8 +104,12 @@ const (
 	ERR_CANNOTSENDTOCHAN     = "404"
 	ERR_TOOMANYCHANNELS      = "405"
 	ERR_WASNOSUCHNICK        = "406"
+	ERR_TOOMANYTARGETS       = "407"
+	ERR_NOORIGIN             = "409"
 	ERR_INVALIDCAPSUBCOMMAND = "410" // ratbox/charybdis(?)
+	ERR_NORECIPIENT          = "411"
 	ERR_NOTEXTTOSEND         = "412"
+	ERR_INPUTTOOLONG         = "417" // ircv3
 	ERR_UNKNOWNCOMMAND       = "421"
 	ERR_NOMOTD               = "422"
 	ERR_ERRONEUSNICKNAME     = "432"
@@ -102,6 +121,8 @@ const (
 	ERR_NOTREGISTERED        = "451"
 	ERR_NEEDMOREPARAMS       = "461"
 	ERR_ALREADYREGISTERED    = "462"
+	ERR_PASSWDMISMATCH       = "464"
+	ERR_YOUREBANNEDCREEP     = "465"
 	ERR_UNKNOWNMODE          = "472"
 
 	ERR_BADCHANNELKEY  = "475"
@@ -123,8 +144,29 @@ const (
 	ERR_CANTJOINOPERSONLY = "520" // unrealircd, but crap to have so many numerics for cant join..
 	ERR_CANTSENDTOUSER    = "531" // ???
 
-	ERR_CHANOPEN    = "713"
-	ERR_KNOCKONCHAN = "714"
+	RPL_WHOISSECURE = "671"
+
+	RPL_KNOCK        = "710" // charybdis
+	RPL_KNOCKDLVR    = "711"
+	ERR_TOOMANYKNOCK = "712"
+	ERR_CHANOPEN     = "713"
+	ERR_KNOCKONCHAN  = "714"
+
+	RPL_MONONLINE    = "730" // ircv3 monitor
+	RPL_MONOFFLINE   = "731"
+	RPL_MONLIST      = "732"
+	RPL_ENDOFMONLIST = "733"
+	ERR_MONLISTFULL  = "734"
+
+	RPL_LOGGEDIN    = "900" // ircv3 sasl
+	RPL_LOGGEDOUT   = "901"
+	ERR_NICKLOCKED  = "902"
+	RPL_SASLSUCCESS = "903"
+	ERR_SASLFAIL    = "904"
+	ERR_SASLTOOLONG = "905"
+	ERR_SASLABORTED = "906"
+	ERR_SASLALREADY = "907"
+	RPL_SASLMECHS   = "908"
 
 	// added by syed
 	RPL_USERHOST         = "302"
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+func (user *ircUser) isOper() bool {
+	return strings.Contains(user.Modes, "o")
+}
+
+func (server *Server) operNotice(text string) {
+	// Server notice to every operator.
+	for _, u := range server.Clients {
+		if u.isOper() {
+			u.serverWrite(u.Nick, "NOTICE", "*** "+text)
+		}
+	}
+}
+
+func IRC_OPER(msg *ircMessage) (string, string) {
+	// OPER <name> [password]
+	// The password can be left out for blocks that only check a certificate fingerprint.
+	user := msg.User
+	password := ""
+	if len(msg.Params) > 1 {
+		password = msg.Params[1]
+	}
+	userhost := user.User + "@" + user.getHostAddr()
+	for _, block := range msg.Server.Config.Opers {
+		if block.Name != msg.Params[0] {
+			continue
+		}
+		hostOK := len(block.Hosts) == 0
+		for _, mask := range block.Hosts {
+			hostOK = hostOK || matchMask(mask, userhost)
+		}
+		if !hostOK {
+			return ERR_NOOPERHOST, ":No O-lines for your host"
+		}
+		if block.CertFP != "" && normalizeCertFP(block.CertFP) != user.CertFP {
+			break
+		}
+		if block.Password != "" && subtle.ConstantTimeCompare([]byte(block.Password), []byte(password)) != 1 {
+			break
+		}
+		if !user.isOper() {
+			user.Modes += "o"
+			user.Command("MODE", "+o")
+		}
+		msg.Server.operNotice(user.Nick + " (" + userhost + ") is now an operator (" + block.Name + ")")
+		return RPL_YOUAREOPER, ":You are now an IRC operator"
+	}
+	return ERR_PASSWDMISMATCH, ":Password incorrect"
+}
+
+func IRC_REHASH(msg *ircMessage) (string, string) {
+	// REHASH
+	if !msg.User.isOper() {
+		return ERR_NOPRIVILEGES, ":Permission Denied- You're not an IRC operator"
+	}
+	msg.User.sendNumeric(RPL_REHASHING, msg.Server.Config.Path, ":Rehashing")
+	msg.Server.operNotice(msg.User.Nick + " is rehashing the server config file")
+	msg.Server.reportRehash(msg.Server.rehash())
+	return "", ""
+}
+
+func (server *Server) rehash() (rejected []string, err error) {
+	// Re-reads the config file and swaps it in whole. Settings that can't
+	// change under connected clients keep their old values and are reported back.
+	conf, err := loadConfig(server.Config.Path)
+	if err != nil {
+		return nil, err
+	}
+	old := server.Config
+
+	if conf.Server.Host != old.Server.Host {
+		rejected = append(rejected, "server.host can't change without a restart")
+		conf.Server.Host = old.Server.Host
+	}
+	if conf.Server.caseMapping() != old.Server.caseMapping() {
+		// Every nick and channel is keyed by the old folding.
+		rejected = append(rejected, "server.casemapping can't change without a restart")
+		conf.Server.CaseMapping = old.Server.CaseMapping
+	}
+
+	server.applyConfig(conf)
+	server.updateCaps()
+	rejected = append(rejected, server.openListeners()...)
+	return rejected, nil
+}
+
+func (server *Server) reportRehash(rejected []string, err error) {
+	if err != nil {
+		fmt.Println("Rehash failed:", err)
+		for _, line := range strings.Split(err.Error(), "\n") {
+			server.operNotice("Rehash failed: " + line)
+		}
+		return
+	}
+	fmt.Println("Rehashed", server.Config.Path)
+	for _, reason := range rejected {
+		server.operNotice("Rehash: " + reason)
+	}
+	server.operNotice("Rehash of " + server.Config.Path + " complete")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// How often connections are checked for pings due or overdue.
+const pingCheckInterval = time.Second
+
+func (server *Server) checkPings() {
+	// Runs for the life of the server. The checking itself happens on the message goroutine.
+	for range time.Tick(pingCheckInterval) {
+		server.Messages <- ircMessage{Server: server, Event: server.pingClients}
+	}
+}
+
+func (server *Server) pingClients() {
+	// PINGs anyone quiet for their class' pingfreq, and drops anyone
+	// who hasn't sent anything within pingtimeout of that PING.
+	now := time.Now()
+	users := make([]*ircUser, 0, len(server.Clients)+len(server.Unregistered))
+	for _, u := range server.Clients {
+		users = append(users, u)
+	}
+	for _, u := range server.Unregistered {
+		users = append(users, u)
+	}
+	for _, user := range users {
+		switch {
+		case user.PingToken == "" && now.Sub(user.LastSeen) >= user.Class.pingFreq():
+			user.PingToken = strconv.FormatInt(now.UnixNano(), 36)
+			user.PingSent = now
+			user.sendMessage("", "PING", user.PingToken)
+		case user.PingToken != "" && now.Sub(user.PingSent) >= user.Class.pingTimeout():
+			user.quit(fmt.Sprintf("Ping timeout: %d seconds", int(now.Sub(user.LastSeen).Seconds())))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Ping_Reply(t *testing.T) {
+	server := mock_user().Server
+	alice := mock_client(server, "alice")
+
+	send(alice, "PING :LAG 123")
+	send(alice, "PING")
+	lines := drain(alice)
+	if len(lines) != 2 || lines[0] != ":TestIRCd.testserver.net PONG TestIRCd.testserver.net :LAG 123" ||
+		!strings.HasSuffix(lines[1], " 409 alice :No origin specified") {
+		t.Errorf("PING gave %q", lines)
+	}
+	send(alice, "PONG TestIRCd.testserver.net x")
+	if lines := drain(alice); len(lines) != 0 {
+		t.Errorf("PONG gave %q", lines)
+	}
+}
+
+func Test_Ping_Timeout(t *testing.T) {
+	server := mock_user().Server
+	server.Config.Classes = []ClassConfig{{Name: "default", PingFreq: 30, PingTimeout: 10}}
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	send(alice, "JOIN #chan")
+	send(bob, "JOIN #chan")
+	drain(alice)
+	drain(bob)
+	for _, user := range []*ircUser{alice, bob} {
+		user.Class = server.Config.class("default")
+		user.LastSeen = time.Now().Add(-31 * time.Second)
+	}
+
+	server.pingClients()
+	lines := drain(alice)
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "PING ") || alice.PingToken == "" || lines[0] != "PING "+alice.PingToken {
+		t.Fatalf("quiet client got %q", lines)
+	}
+	drain(bob)
+	server.pingClients()
+	if lines := drain(alice); len(lines) != 0 {
+		t.Errorf("pinged again before the timeout: %q", lines)
+	}
+
+	// alice answers, bob doesn't.
+	send(alice, "PONG "+alice.PingToken)
+	alice.PingSent = alice.PingSent.Add(-11 * time.Second)
+	bob.PingSent = bob.PingSent.Add(-11 * time.Second)
+	server.pingClients()
+	if alice.State == stateDisconnected || alice.PingToken != "" {
+		t.Error("alice was dropped after answering")
+	}
+	if bob.State != stateDisconnected {
+		t.Fatal("bob wasn't dropped")
+	}
+	if lines := drain(alice); len(lines) != 1 || lines[0] != ":bob!~bob@127.0.0.1 QUIT :Ping timeout: 31 seconds" {
+		t.Errorf("alice got %q", lines)
+	}
+}
+
+func Test_Quit_Dead_Peer(t *testing.T) {
+	// Nobody reads the other end, like a peer whose send buffer is full.
+	quitWriteTimeout = 50 * time.Millisecond
+	defer func() { quitWriteTimeout = 10 * time.Second }()
+	conn, _ := net.Pipe()
+	user := &ircUser{Nick: "AUTH", Server: mock_user().Server, Conn: conn, Writer: make(chan string)}
+	done := make(chan bool)
+	go func() {
+		user.writeLoop(1024)
+		close(done)
+	}()
+
+	user.write("PING x")
+	user.quit("Ping timeout: 180 seconds")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("writes to a dead peer kept the connection open")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+func IRC_PRIVMSG(msg *ircMessage) (string, string) {
+	// PRIVMSG <target>{,<target>} <text>
+	msg.User.deliver(msg, false)
+	return "", ""
+}
+
+func IRC_NOTICE(msg *ircMessage) (string, string) {
+	// NOTICE <target>{,<target>} <text>
+	// Never replied to, not even with errors, so two bots can't loop on each other.
+	msg.User.deliver(msg, true)
+	return "", ""
+}
+
+func (user *ircUser) deliver(msg *ircMessage, notice bool) {
+	reply := func(numeric string, args ...string) {
+		if !notice {
+			user.sendNumeric(numeric, args...)
+		}
+	}
+	if len(msg.Params) == 0 || msg.Params[0] == "" {
+		reply(ERR_NORECIPIENT, ":No recipient given ("+msg.Command+")")
+		return
+	}
+	if len(msg.Params) < 2 || msg.Params[1] == "" {
+		reply(ERR_NOTEXTTOSEND, ":No text to send")
+		return
+	}
+
+	targets := strings.Split(msg.Params[0], ",")
+	if limit := user.Server.Config.Limits.MaxTargets; len(targets) > limit {
+		reply(ERR_TOOMANYTARGETS, msg.Params[0], ":Too many targets, the maximum is "+strconv.Itoa(limit))
+		return
+	}
+	// Cut the text so the relayed line still fits in 512 bytes.
+	text := func(target string) string {
+		return truncate(msg.Params[1], maxLineLength-2-len(":"+user.Host+" "+msg.Command+" "+target+" :"))
+	}
+	delivered := false
+	for _, target := range targets {
+		if strings.HasPrefix(target, "#") {
+			channel, ok := user.Server.findChannel(target)
+			if !ok {
+				reply(ERR_NOSUCHNICK, target, ":No such nick/channel")
+				continue
+			}
+			if !channel.canSend(user) {
+				reply(ERR_CANNOTSENDTOCHAN, channel.Name, ":Cannot send to channel")
+				continue
+			}
+			channel.sendExcept(user, user.Host, msg.Command, channel.Name, text(channel.Name))
+			delivered = true
+			continue
+		}
+
+		recipient, ok := user.Server.findUser(target)
+		if !ok {
+			reply(ERR_NOSUCHNICK, target, ":No such nick/channel")
+			continue
+		}
+		recipient.sendMessage(user.Host, msg.Command, recipient.Nick, text(recipient.Nick))
+		delivered = true
+		if recipient.AWAY {
+			reply(RPL_AWAY, recipient.Nick, ":"+recipient.AwayMsg)
+		}
+	}
+	if delivered { // Failed sends don't count against idle time.
+		user.LastActive = time.Now()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func Test_Privmsg(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	send(alice, "JOIN #test")
+	send(bob, "JOIN #test")
+	drain(alice)
+	drain(bob)
+
+	send(alice, "PRIVMSG BOB,#test :hi there")
+	if lines := drain(bob); len(lines) != 2 || lines[0] != ":alice!~alice@127.0.0.1 PRIVMSG bob :hi there" ||
+		lines[1] != ":alice!~alice@127.0.0.1 PRIVMSG #test :hi there" {
+		t.Errorf("bob got %q", lines)
+	}
+	if lines := drain(alice); len(lines) != 0 {
+		t.Errorf("alice's own message came back: %q", lines)
+	}
+
+	send(carol, "PRIVMSG #test :let me in")
+	if lines := drain(carol); len(lines) != 1 || !strings.Contains(lines[0], " 404 carol #test ") {
+		t.Errorf("outsider got %q", lines)
+	}
+	send(carol, "PRIVMSG nobody :hello")
+	send(carol, "PRIVMSG bob")
+	send(carol, "PRIVMSG a,b,c,d,e :hello")
+	lines := drain(carol)
+	if len(lines) != 3 || !strings.Contains(lines[0], " 401 ") || !strings.Contains(lines[1], " 412 ") ||
+		!strings.Contains(lines[2], " 407 ") {
+		t.Errorf("errors were %q", lines)
+	}
+	if lines := drain(bob); len(lines) != 0 {
+		t.Errorf("bob got %q", lines)
+	}
+
+	send(carol, "NOTICE nobody,#test :hello")
+	send(carol, "NOTICE bob")
+	if lines := drain(carol); len(lines) != 0 {
+		t.Errorf("NOTICE was answered with %q", lines)
+	}
+}
+
+func Test_Privmsg_Relay_Length(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	idle := time.Now().Add(-time.Hour)
+	alice.LastActive = idle
+
+	send(alice, "PRIVMSG nobody :hello")
+	drain(alice)
+	if !alice.LastActive.Equal(idle) {
+		t.Error("a failed PRIVMSG reset the idle time")
+	}
+
+	send(alice, "PRIVMSG bob :"+strings.Repeat("é", 245))
+	lines := drain(bob)
+	if len(lines) != 1 || len(lines[0]) > maxLineLength-2 || !strings.HasPrefix(lines[0], ":alice!~alice@127.0.0.1 PRIVMSG bob éé") {
+		t.Fatalf("bob got %q", lines)
+	}
+	if !utf8.ValidString(lines[0]) {
+		t.Error("the relayed text was cut mid-character")
+	}
+	if alice.LastActive.Equal(idle) {
+		t.Error("PRIVMSG didn't reset the idle time")
+	}
+}
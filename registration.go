@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+)
+
+type regState int
+
+const (
+	statePreRegistration regState = iota // Waiting on NICK and USER.
+	stateCapNegotiating                  // Client started CAP negotiation, waiting on CAP END.
+	stateRegistered                      // Fully connected.
+	stateDisconnected                    // Gone, but may still have messages queued.
+)
+
+func (user *ircUser) isRegistered() bool {
+	return user.State == stateRegistered
+}
+
+func (user *ircUser) tryRegister() {
+	// Completes registration once everything the client owes us is in.
+	// Called after every command that can move registration forward.
+	if user.State != statePreRegistration {
+		return // Already registered, or CAP END hasn't arrived yet.
+	}
+	if user.Nick == "AUTH" || user.User == "" {
+		return
+	}
+	user.abortSASL()
+	if pass := user.Server.Config.Server.Password; pass != "" &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(user.Pass)) != 1 {
+		user.sendNumeric(ERR_PASSWDMISMATCH, ":Password incorrect")
+		user.quit("Bad password")
+		return
+	}
+	if ban, banned := user.Server.Config.findBan(user.User + "@" + user.getHostAddr()); banned {
+		user.sendNumeric(ERR_YOUREBANNEDCREEP, ":You are banned from this server ("+ban.Reason+")")
+		user.quit("Banned")
+		return
+	}
+	user.identifyByCertFP()
+	user.updateUser() // Register User
+	user.State = stateRegistered
+	user.Signon, user.LastActive = time.Now(), time.Now()
+	user.Server.notifyMonitors(user, true)
+	user.sendWelcome()
+}
+
+func (user *ircUser) sendWelcome() {
+	server := user.Server
+	// WELCOME messages
+	user.sendNumeric(RPL_WELCOME, ":Welcome to the "+server.Network+" Internet Relay Chat Network "+
+		user.Host)
+	user.sendNumeric(RPL_YOURHOST, ":Your host is "+server.Host+", running version "+ircdVersion)
+	user.sendNumeric(RPL_CREATED, ":This server was created "+server.Created.Format("Mon Jan 2 2006 at 15:04:05 MST"))
+	user.sendNumeric(RPL_SERVERVERSION, server.Host, ircdVersion, userModes.letters(), channelModes.letters(),
+		channelModes.letters(modeList, modeAlways, modeOnSet, modePrefix))
+	user.sendISupport()
+	user.sendMOTD()
+
+	user.Modes = "i"
+	user.Command("MODE", "+i")
+	fmt.Println("Sent welcome messages and MOTD to:", user.Nick)
+}
+
+func (user *ircUser) sendMOTD() {
+	motd := user.Server.Config.MOTD
+	if len(motd) == 0 {
+		user.sendNumeric(ERR_NOMOTD, ":MOTD File is missing")
+		return
+	}
+	user.sendNumeric(RPL_MOTDSTART, ":- "+user.Server.Host+" Message of the Day -")
+	for _, line := range motd {
+		user.sendNumeric(RPL_MOTD, ":- "+line)
+	}
+	user.sendNumeric(RPL_ENDOFMOTD, ":End of /MOTD")
+}
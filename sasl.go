@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	saslChunkSize   = 400       // AUTHENTICATE payloads are split into chunks of this size.
+	saslMaxLength   = 10 * 1024 // Largest decoded client response we'll buffer.
+	saslMaxFailures = 3         // Failed attempts a connection gets before SASL is refused outright.
+)
+
+var errSASLFailed = errors.New("SASL authentication failed")
+
+type saslMechanism interface {
+	// step takes one complete, decoded client response. It returns the next
+	// challenge to send, or the account name once authentication is done.
+	step(user *ircUser, response []byte) (challenge []byte, account string, err error)
+}
+
+// A mechanism whose final check is too slow for the message goroutine (PLAIN
+// hashes the password) implements this. Its step returns no account and no
+// challenge, and check is then run on its own goroutine.
+type saslChecker interface {
+	check(accounts AccountStore) (account string, err error)
+}
+
+var saslMechanisms = map[string]func() saslMechanism{
+	"PLAIN":         func() saslMechanism { return &saslPlain{} },
+	"EXTERNAL":      func() saslMechanism { return &saslExternal{} },
+	"SCRAM-SHA-256": func() saslMechanism { return &saslScram{} },
+}
+
+type saslSession struct {
+	mech   saslMechanism
+	buffer string // Base64 chunks received so far.
+}
+
+func init() {
+	registerCap(capability{Name: "sasl", Value: func(*ircUser) string { return saslMechanismList() }})
+	registerCap(capability{Name: "account-notify"})
+}
+
+func saslMechanismList() string {
+	names := make([]string, 0, len(saslMechanisms))
+	for name := range saslMechanisms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func IRC_AUTHENTICATE(msg *ircMessage) (string, string) {
+	// AUTHENTICATE <mechanism> / AUTHENTICATE <base64 chunk> / AUTHENTICATE *
+	user := msg.User
+	param := msg.Params[0]
+
+	if !user.hasCap("sasl") {
+		return ERR_SASLFAIL, ":SASL authentication failed"
+	}
+	if user.Account != "" {
+		return ERR_SASLALREADY, ":You have already authenticated using SASL"
+	}
+	if param == "*" {
+		user.SASL = nil
+		return ERR_SASLABORTED, ":SASL authentication aborted"
+	}
+	if user.SASLBusy {
+		return "", "" // The answer is on its way.
+	}
+	if user.SASLFails >= saslMaxFailures {
+		user.SASL = nil
+		return ERR_SASLFAIL, ":Too many failed SASL attempts"
+	}
+
+	// First message names the mechanism.
+	if user.SASL == nil {
+		newMech, ok := saslMechanisms[strings.ToUpper(param)]
+		if !ok {
+			user.sendNumeric(RPL_SASLMECHS, saslMechanismList(), ":are available SASL mechanisms")
+			return user.failSASL()
+		}
+		user.SASL = &saslSession{mech: newMech()}
+		user.sendMessage("", "AUTHENTICATE", "+")
+		return "", ""
+	}
+
+	if len(param) > saslChunkSize || len(user.SASL.buffer)+len(param) > saslMaxLength*4/3 {
+		user.SASL = nil
+		return ERR_SASLTOOLONG, ":SASL message too long"
+	}
+	if param != "+" {
+		user.SASL.buffer += param
+	}
+	if len(param) == saslChunkSize {
+		return "", "" // More chunks to come.
+	}
+
+	response, err := base64.StdEncoding.DecodeString(user.SASL.buffer)
+	user.SASL.buffer = ""
+	if err != nil {
+		return user.failSASL()
+	}
+
+	challenge, account, err := user.SASL.mech.step(user, response)
+	if err != nil {
+		return user.failSASL()
+	}
+	if checker, ok := user.SASL.mech.(saslChecker); ok && account == "" && challenge == nil {
+		user.checkSASL(checker)
+		return "", ""
+	}
+	if account == "" {
+		user.sendSASLChallenge(challenge)
+		return "", ""
+	}
+	return user.finishSASL(account)
+}
+
+func (user *ircUser) checkSASL(checker saslChecker) {
+	// Runs the check off the message goroutine, then finishes on it.
+	session, accounts := user.SASL, user.Server.Accounts
+	user.SASLBusy = true
+	go func() {
+		account, err := checker.check(accounts)
+		user.Server.Messages <- ircMessage{User: user, Server: user.Server, Event: func() {
+			user.SASLBusy = false
+			if user.SASL != session || user.State == stateDisconnected {
+				return // Aborted, or registration went ahead without it.
+			}
+			if err != nil {
+				user.sendNumeric(user.failSASL())
+			} else {
+				user.sendNumeric(user.finishSASL(account))
+			}
+		}}
+	}()
+}
+
+func (user *ircUser) failSASL() (string, string) {
+	user.SASL = nil
+	user.SASLFails++
+	return ERR_SASLFAIL, ":SASL authentication failed"
+}
+
+func (user *ircUser) finishSASL(account string) (string, string) {
+	user.SASL = nil
+	user.Account = account
+	if user.isRegistered() {
+		user.notifyChange("account-notify", "ACCOUNT", account)
+	}
+	user.sendNumeric(RPL_LOGGEDIN, user.saslMask(), account, ":You are now logged in as "+account)
+	return RPL_SASLSUCCESS, ":SASL authentication successful"
+}
+
+func (user *ircUser) sendSASLChallenge(challenge []byte) {
+	encoded := base64.StdEncoding.EncodeToString(challenge)
+	for len(encoded) >= saslChunkSize {
+		user.sendMessage("", "AUTHENTICATE", encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+	// An empty final chunk is "+", which also terminates an exact multiple of 400.
+	if encoded == "" {
+		encoded = "+"
+	}
+	user.sendMessage("", "AUTHENTICATE", encoded)
+}
+
+func (user *ircUser) abortSASL() {
+	// Registration finished with an exchange still in flight.
+	if user.SASL != nil {
+		user.SASL = nil
+		user.sendNumeric(ERR_SASLABORTED, ":SASL authentication aborted")
+	}
+}
+
+func (user *ircUser) saslMask() string {
+	// nick!ident@host, usable before registration fills in user.Host.
+	ident := user.User
+	if ident == "" {
+		ident = "*"
+	}
+	return user.capNick() + "!" + ident + "@" + user.getHostAddr()
+}
+
+type saslPlain struct {
+	authzid, authcid, password string
+}
+
+func (m *saslPlain) step(user *ircUser, response []byte) ([]byte, string, error) {
+	// authzid \0 authcid \0 password
+	parts := bytes.Split(response, []byte{0})
+	if len(parts) != 3 {
+		return nil, "", errSASLFailed
+	}
+	m.authzid, m.authcid, m.password = string(parts[0]), string(parts[1]), string(parts[2])
+	return nil, "", nil // The password is checked by check.
+}
+
+func (m *saslPlain) check(accounts AccountStore) (string, error) {
+	account, ok := accounts.CheckPassword(m.authcid, m.password)
+	if !ok || (m.authzid != "" && !strings.EqualFold(m.authzid, account)) {
+		return "", errSASLFailed
+	}
+	return account, nil
+}
+
+type saslExternal struct{}
+
+func (m *saslExternal) step(user *ircUser, response []byte) ([]byte, string, error) {
+	// [authzid], identity comes from the TLS client certificate.
+	if user.CertFP == "" {
+		return nil, "", errSASLFailed
+	}
+	account, ok := user.Server.Accounts.CertFPAccount(user.CertFP)
+	if !ok || (len(response) > 0 && !strings.EqualFold(string(response), account)) {
+		return nil, "", errSASLFailed
+	}
+	return nil, account, nil
+}
+
+type saslScram struct {
+	// RFC 5802 with SHA-256 (RFC 7677). Channel binding isn't supported.
+	state           int
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	account         string
+	creds           scramCredentials
+}
+
+func (m *saslScram) step(user *ircUser, response []byte) ([]byte, string, error) {
+	switch m.state {
+	case 0:
+		return m.clientFirst(user, string(response))
+	case 1:
+		return m.clientFinal(string(response))
+	case 2:
+		// Client acknowledged our server signature.
+		return nil, m.account, nil
+	}
+	return nil, "", errSASLFailed
+}
+
+func (m *saslScram) clientFirst(user *ircUser, response string) ([]byte, string, error) {
+	// gs2-cbind-flag "," [authzid] "," n=user,r=nonce[,extensions]
+	parts := strings.SplitN(response, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+		return nil, "", errSASLFailed
+	}
+	m.gs2Header = parts[0] + "," + parts[1] + ","
+	m.clientFirstBare = parts[2]
+
+	attrs := scramAttributes(m.clientFirstBare)
+	username, cnonce := attrs["n"], attrs["r"]
+	if username == "" || cnonce == "" || attrs["m"] != "" {
+		return nil, "", errSASLFailed
+	}
+	username = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(username)
+
+	account, creds, ok := user.Server.Accounts.ScramCredentials(username)
+	if !ok {
+		return nil, "", errSASLFailed
+	}
+	if authzid := strings.TrimPrefix(parts[1], "a="); authzid != "" && !strings.EqualFold(authzid, account) {
+		return nil, "", errSASLFailed
+	}
+	m.account, m.creds = account, creds
+
+	snonce := make([]byte, 18)
+	rand.Read(snonce)
+	m.nonce = cnonce + base64.StdEncoding.EncodeToString(snonce)
+	m.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", m.nonce, base64.StdEncoding.EncodeToString(creds.Salt), creds.Iterations)
+	m.state = 1
+	return []byte(m.serverFirst), "", nil
+}
+
+func (m *saslScram) clientFinal(response string) ([]byte, string, error) {
+	// c=<gs2 header>,r=<nonce>[,extensions],p=<proof>
+	i := strings.LastIndex(response, ",p=")
+	if i < 0 {
+		return nil, "", errSASLFailed
+	}
+	withoutProof := response[:i]
+	proof, err := base64.StdEncoding.DecodeString(response[i+3:])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, "", errSASLFailed
+	}
+	attrs := scramAttributes(withoutProof)
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(m.gs2Header)) || attrs["r"] != m.nonce {
+		return nil, "", errSASLFailed
+	}
+
+	authMessage := m.clientFirstBare + "," + m.serverFirst + "," + withoutProof
+	signature := hmacSHA256(m.creds.StoredKey, authMessage)
+	clientKey := make([]byte, sha256.Size)
+	for i := range clientKey {
+		clientKey[i] = proof[i] ^ signature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if !hmac.Equal(storedKey[:], m.creds.StoredKey) {
+		return nil, "", errSASLFailed
+	}
+
+	m.state = 2
+	verifier := hmacSHA256(m.creds.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(verifier)), "", nil
+}
+
+func scramAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok && len(k) == 1 {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sasl_user() *ircUser {
+	user := mock_user()
+	user.Writer = make(chan string, 100)
+	user.Server.Accounts.(*memoryAccounts).addAccount("Syed", "hunter2")
+	for _, line := range []string{"CAP REQ sasl", "NICK Test"} {
+		msg := mock_message(line, &user)
+		msg.handleCommand()
+	}
+	<-user.Writer // CAP ACK
+	return &user
+}
+
+func sasl_send(user *ircUser, payload string) string {
+	msg := mock_message("AUTHENTICATE "+payload, user)
+	msg.handleCommand()
+	return <-user.Writer
+}
+
+func sasl_check(t *testing.T, user *ircUser, payload string) string {
+	// PLAIN answers once the password check is back on the message goroutine.
+	msg := mock_message("AUTHENTICATE "+payload, user)
+	msg.handleCommand()
+	select {
+	case event := <-user.Server.Messages:
+		event.Event()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the password check never finished")
+	}
+	return <-user.Writer
+}
+
+func Test_SASL_Plain(t *testing.T) {
+	user := sasl_user()
+	if reply := sasl_send(user, "PLAIN"); reply != "AUTHENTICATE +" {
+		t.Fatalf("Expected empty challenge, got %q", reply)
+	}
+	bad := base64.StdEncoding.EncodeToString([]byte("\x00syed\x00wrong"))
+	if reply := sasl_check(t, user, bad); !strings.Contains(reply, " "+ERR_SASLFAIL+" ") {
+		t.Errorf("Wrong password got %q", reply)
+	}
+
+	sasl_send(user, "PLAIN")
+	good := base64.StdEncoding.EncodeToString([]byte("\x00syed\x00hunter2"))
+	if reply := sasl_check(t, user, good); !strings.Contains(reply, " "+RPL_LOGGEDIN+" ") {
+		t.Errorf("Expected RPL_LOGGEDIN, got %q", reply)
+	}
+	if reply := <-user.Writer; !strings.Contains(reply, " "+RPL_SASLSUCCESS+" ") || user.Account != "Syed" {
+		t.Errorf("Expected RPL_SASLSUCCESS, got %q (account %q)", reply, user.Account)
+	}
+}
+
+func Test_SASL_Failures(t *testing.T) {
+	user := sasl_user()
+	bad := base64.StdEncoding.EncodeToString([]byte("\x00syed\x00wrong"))
+	for range saslMaxFailures {
+		sasl_send(user, "PLAIN")
+		sasl_check(t, user, bad)
+	}
+	if reply := sasl_send(user, "PLAIN"); !strings.HasSuffix(reply, " 904 Test :Too many failed SASL attempts") {
+		t.Errorf("another attempt got %q", reply)
+	}
+}
+
+func Test_SASL_Scram(t *testing.T) {
+	user := sasl_user()
+	sasl_send(user, "SCRAM-SHA-256")
+
+	clientFirstBare := "n=syed,r=clientnonce"
+	reply := sasl_send(user, base64.StdEncoding.EncodeToString([]byte("n,,"+clientFirstBare)))
+	serverFirst, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(reply, "AUTHENTICATE "))
+	attrs := scramAttributes(string(serverFirst))
+	if !strings.HasPrefix(attrs["r"], "clientnonce") {
+		t.Fatalf("Bad server-first message %q", serverFirst)
+	}
+
+	// Compute the client proof the way a client would.
+	salt, _ := base64.StdEncoding.DecodeString(attrs["s"])
+	storedKey, serverKey := deriveScramKeys("hunter2", salt, scramIterations)
+	withoutProof := "c=biws,r=" + attrs["r"]
+	authMessage := clientFirstBare + "," + string(serverFirst) + "," + withoutProof
+	signature := hmacSHA256(storedKey, authMessage)
+	clientKey := clientKeyFor("hunter2", salt)
+	proof := make([]byte, sha256.Size)
+	for i := range proof {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	final := withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+
+	reply = sasl_send(user, base64.StdEncoding.EncodeToString([]byte(final)))
+	verifier, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(reply, "AUTHENTICATE "))
+	if string(verifier) != "v="+base64.StdEncoding.EncodeToString(hmacSHA256(serverKey, authMessage)) {
+		t.Fatalf("Bad server signature %q", verifier)
+	}
+	if reply := sasl_send(user, "+"); !strings.Contains(reply, " "+RPL_LOGGEDIN+" ") || user.Account != "Syed" {
+		t.Errorf("Expected RPL_LOGGEDIN, got %q", reply)
+	}
+}
+
+func clientKeyFor(password string, salt []byte) []byte {
+	salted, _ := pbkdf2.Key(sha256.New, password, salt, scramIterations, sha256.Size)
+	return hmacSHA256(salted, "Client Key")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	certCheckInterval = 30 * time.Second // How often certificate files are checked for changes on disk.
+	handshakeTimeout  = 10 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type certFile struct {
+	Cert     *tls.Certificate
+	CertPath string
+	KeyPath  string
+	ModTime  time.Time // Newest of the two files when they were loaded.
+}
+
+type tlsState struct {
+	// Read by every handshake, so both are swapped whole rather than modified.
+	config atomic.Pointer[tls.Config]
+	cert   atomic.Pointer[certFile]
+}
+
+func init() {
+	registerCap(capability{
+		Name:      "sts",
+		Value:     stsValue,
+		Available: func(server *Server) bool { return server.Config.STS.Port != 0 },
+	})
+}
+
+func stsValue(user *ircUser) string {
+	// Plaintext clients are told where to upgrade to, TLS clients how long to remember it.
+	sts := user.Server.Config.STS
+	if !user.Secure {
+		return "port=" + strconv.Itoa(sts.Port)
+	}
+	value := "duration=" + strconv.Itoa(sts.Duration)
+	if sts.Preload {
+		value += ",preload"
+	}
+	return value
+}
+
+func loadCertFile(certPath string, keyPath string) (*certFile, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &certFile{&cert, certPath, keyPath, certModTime(certPath, keyPath)}, nil
+}
+
+func certModTime(paths ...string) (newest time.Time) {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return
+}
+
+func (state *tlsState) apply(conf TLSConfig, cert *certFile) {
+	// Only new handshakes see the change, existing sessions keep going.
+	config := &tls.Config{
+		MinVersion:     tlsVersions[conf.minVersion()],
+		GetCertificate: state.getCertificate,
+		// Client certificates are optional and self-signed ones are fine,
+		// they're only used for their fingerprint.
+		ClientAuth: tls.RequestClientCert,
+	}
+	for _, name := range conf.Ciphers {
+		config.CipherSuites = append(config.CipherSuites, cipherSuites()[name])
+	}
+	if cert != nil {
+		state.cert.Store(cert)
+	}
+	state.config.Store(config)
+}
+
+func (state *tlsState) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := state.cert.Load(); cert != nil {
+		return cert.Cert, nil
+	}
+	return nil, fmt.Errorf("no TLS certificate configured")
+}
+
+func (state *tlsState) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return state.config.Load(), nil
+}
+
+func (state *tlsState) watchCertificates() {
+	// Picks up renewed certificates without waiting for a REHASH.
+	for range time.Tick(certCheckInterval) {
+		state.reloadChanged()
+	}
+}
+
+func (state *tlsState) reloadChanged() {
+	current := state.cert.Load()
+	if current == nil || !certModTime(current.CertPath, current.KeyPath).After(current.ModTime) {
+		return
+	}
+	cert, err := loadCertFile(current.CertPath, current.KeyPath)
+	if err != nil {
+		fmt.Println("Not reloading TLS certificate:", err)
+		return
+	}
+	// Skip if a rehash swapped in different files meanwhile.
+	if state.cert.CompareAndSwap(current, cert) {
+		fmt.Println("Reloaded TLS certificate", cert.CertPath)
+	}
+}
+
+func certFingerprint(conn *tls.Conn) string {
+	// Hex SHA-256 of the client's certificate, or "" if it didn't send one.
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeCertFP(fp string) string {
+	// Accepts the "AB:CD:..." form openssl prints as well.
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
+
+func (user *ircUser) identifyByCertFP() {
+	// Logs the user in to the account their certificate is bound to, if they didn't use SASL.
+	if user.Account != "" || user.CertFP == "" {
+		return
+	}
+	if account, ok := user.Server.Accounts.CertFPAccount(user.CertFP); ok {
+		user.Account = account
+		user.sendNumeric(RPL_LOGGEDIN, user.saslMask(), account, ":You are now logged in as "+account)
+	}
+}
+
+func cipherSuites() map[string]uint16 {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	return suites
+}
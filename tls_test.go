@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir string, name string) (certPath string, keyPath string) {
+	// Self-signed certificate, good enough for both ends of a test connection.
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPath, keyPath = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
+
+func tlsServer(t *testing.T, extra string) (*Server, string) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "server")
+	os.WriteFile(filepath.Join(dir, "ircd.json"), []byte(`{
+		"listeners": [{"address": "127.0.0.1:0"}, {"address": "127.0.0.1:0", "tls": true, "class": "secure"}],
+		"classes": [{"name": "secure"}],
+		`+extra+`
+		"tls": {"cert": "server.crt", "key": "server.key", "min_version": "1.3"}
+	}`), 0600)
+	conf, err := loadConfig(filepath.Join(dir, "ircd.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(conf)
+	go handleMessages(server.Messages)
+	if failed := server.openListeners(); len(failed) > 0 {
+		t.Fatal(failed)
+	}
+	for _, l := range server.Listeners {
+		if l.Conf.TLS {
+			return server, l.ln.Addr().String()
+		}
+	}
+	t.Fatal("No TLS listener")
+	return nil, ""
+}
+
+func Test_TLS_Listener(t *testing.T) {
+	server, addr := tlsServer(t, "")
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.ConnectionState().Version != tls.VersionTLS13 {
+		t.Error("min_version not applied")
+	}
+	first := conn.ConnectionState().PeerCertificates[0]
+
+	// A renewed certificate on disk is picked up for new handshakes.
+	dir := filepath.Dir(server.Config.Path)
+	writeTestCert(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	server.TLS.reloadChanged()
+
+	conn2, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if conn2.ConnectionState().PeerCertificates[0].Equal(first) {
+		t.Error("Certificate wasn't reloaded.")
+	}
+
+	// The old session is still usable.
+	conn.Write([]byte("NICK Secure\r\nUSER s 0 * :S\r\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(line, " 001 ") {
+			break
+		}
+	}
+}
+
+func Test_STS_Cap(t *testing.T) {
+	user := mock_user()
+	user.Server.Config.STS = STSConfig{Port: 6697, Duration: 300}
+	user.Server.updateCaps()
+	if !user.Server.Caps["sts"] {
+		t.Fatal("sts not offered")
+	}
+	if v := stsValue(&user); v != "port=6697" {
+		t.Errorf("Plaintext got %q", v)
+	}
+	user.Secure = true
+	if v := stsValue(&user); v != "duration=300" {
+		t.Errorf("TLS got %q", v)
+	}
+}
+
+func readUntil(t *testing.T, reader *bufio.Reader, numeric string) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%v, after %q", err, lines)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+		if strings.Split(line, " ")[1] == numeric {
+			return lines
+		}
+	}
+}
+
+func Test_CertFP(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir, "client")
+	clientCert, _ := tls.LoadX509KeyPair(certPath, keyPath)
+	sum := sha256.Sum256(clientCert.Certificate[0])
+	fp := hex.EncodeToString(sum[:])
+
+	_, addr := tlsServer(t, `"accounts": [{"name": "Bot", "password": "x", "certfp": ["`+strings.ToUpper(fp)+`"]}],
+		"opers": [{"name": "botop", "certfp": "`+fp+`"}],`)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// SASL EXTERNAL during registration.
+	conn.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE EXTERNAL\r\nAUTHENTICATE +\r\n"))
+	lines := readUntil(t, reader, RPL_SASLSUCCESS)
+	if !strings.Contains(strings.Join(lines, "\n"), "fingerprint is "+fp) {
+		t.Errorf("No fingerprint notice in %q", lines)
+	}
+
+	conn.Write([]byte("CAP END\r\nNICK Bot\r\nUSER bot 0 * :Bot\r\nOPER botop\r\n"))
+	readUntil(t, reader, RPL_YOUAREOPER)
+
+	// Without SASL, the certificate still logs in to the account.
+	conn2, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.Write([]byte("NICK Bot2\r\nUSER bot 0 * :Bot\r\n"))
+	lines = readUntil(t, bufio.NewReader(conn2), RPL_WELCOME)
+	if !strings.Contains(lines[len(lines)-2], " "+RPL_LOGGEDIN+" Bot2 Bot2!bot@127.0.0.1 Bot ") {
+		t.Errorf("Expected auto-identify before welcome, got %q", lines)
+	}
+}
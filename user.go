@@ -4,75 +4,167 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// How long a quitting client gets to read what's still queued for it. A dead
+// peer with a full send buffer would otherwise hold the socket until TCP gives up.
+var quitWriteTimeout = 10 * time.Second
+
 type ircUser struct {
-	Nick     string      // nickname at the moment.
-	User     string      // username
-	Host     string      // Userhost
-	Modes    string      // Modes currently
-	AWAY     bool        // If user is away
-	Realname string      // real name
-	Writer   chan string // used to write messages to user
-	Conn     net.Conn    // pointer to connection
-	Server   *Server     // pointer to server
-	NickList []string    // Past 5 nicknames - excluding present
+	Nick       string            // nickname at the moment.
+	User       string            // username
+	Host       string            // Userhost
+	Modes      string            // Modes currently
+	AWAY       bool              // If user is away
+	AwayMsg    string            // Why, while AWAY is set
+	Realname   string            // real name
+	Writer     chan string       // used to write messages to user
+	Conn       net.Conn          // pointer to connection
+	Server     *Server           // pointer to server
+	NickList   []string          // Past 5 nicknames - excluding present, newest first
+	State      regState          // Registration progress
+	Pass       string            // Password sent with PASS, if any
+	Caps       map[string]bool   // Enabled capabilities
+	CapVersion int               // Highest CAP LS version the client sent
+	Account    string            // Account logged in to, if any
+	CertFP     string            // Hex SHA-256 of the TLS client certificate, if any
+	SASL       *saslSession      // SASL exchange in progress
+	SASLFails  int               // Failed SASL attempts on this connection
+	SASLBusy   bool              // A password check is running off the message goroutine
+	Class      ClassConfig       // Connection class from the listener
+	Secure     bool              // Connected over TLS
+	Signon     time.Time         // When registration finished
+	LastActive time.Time         // Last PRIVMSG or NOTICE, for idle times
+	LastSeen   time.Time         // Last line of any kind from the client
+	PingToken  string            // Token of our unanswered PING, if any
+	PingSent   time.Time         // When that PING went out
+	Channels   map[*Channel]bool // Channels the user is in
+	Invites    map[*Channel]bool // Channels the user was invited to and hasn't joined yet
+	LastKnock  time.Time
+	Monitoring map[string]string // MONITORed nicks as they were given, keyed by casefold(nick)
+	List       *listRequest      // LIST waiting for the client to catch up, if any
+
+	sendqExceeded int32 // Set atomically by the writer when the client stops reading
+	queued        int64 // Bytes waiting to be written to the socket, updated atomically by the writer
+	drainBelow    int64 // When set, the writer wakes List once queued is this low
 }
 
 func (user *ircUser) isValidNick(nick string) bool {
 	// nickname   =  ( letter / special ) *8( letter / digit / special / "-" )
 	// special = "[", "]", "\", "`", "_", "^", "{", "|", "}"
 	re_special := "`" + `\[\]\_^{|}`
-	isValid, _ := regexp.MatchString(`^[(A-Za-z)(`+re_special+`)][A-Za-z0-9`+re_special+"]{0,8}$", nick)
+	rest := strconv.Itoa(user.Server.Config.Limits.NickLen - 1) // NICKLEN, less the first character.
+	isValid, _ := regexp.MatchString(`^[(A-Za-z)(`+re_special+`)][A-Za-z0-9`+re_special+"]{0,"+rest+"}$", nick)
 	return isValid
 }
 
 func (user *ircUser) getHostAddr() (h string) {
-	h, _, _ = net.SplitHostPort(user.Conn.LocalAddr().String()) // Return IP/Host
+	if _, ok := user.Conn.RemoteAddr().(*net.UnixAddr); ok {
+		return "localhost" // Local bots on a unix socket.
+	}
+	h, _, _ = net.SplitHostPort(user.Conn.RemoteAddr().String()) // Return IP/Host
+	if strings.HasPrefix(h, ":") {
+		h = "0" + h // "::1" would be read as a trailing parameter.
+	}
 	return
 }
 
 func (user *ircUser) updateNick(nick string) {
 	// More focused on nickname changes.
-	if _, ok := user.Server.Clients[user.Nick]; !ok {
+	server := user.Server
+	if server.Nicks[server.casefold(user.Nick)] == user {
+		delete(server.Nicks, server.casefold(user.Nick))
+	}
+	server.Nicks[server.casefold(nick)] = user
+	if _, ok := server.Clients[server.casefold(user.Nick)]; !ok {
 		user.Nick = nick
-		user.Server.Unregistered[&user.Conn] = user
+		server.Unregistered[&user.Conn] = user
 	} else {
-		delete(user.Server.Clients, user.Nick)
+		user.Server.recordWhowas(user)
+		user.NickList = append([]string{user.Nick}, user.NickList...)
+		if len(user.NickList) > nickListLength {
+			user.NickList = user.NickList[:nickListLength]
+		}
+		user.Server.notifyMonitors(user, false)
+		delete(server.Clients, server.casefold(user.Nick))
 		user.Nick = nick
 		user.updateUser()
+		user.Server.notifyMonitors(user, true)
 	}
 }
 
 func (user *ircUser) updateUser() {
 	// Set host manually - In case provided pointer doesn't have set.
 	user.Host = user.Nick + "!~" + user.User + "@" + user.getHostAddr()
-	user.Server.Clients[user.Nick] = user
+	user.Server.Clients[user.Server.casefold(user.Nick)] = user
+	user.Server.Nicks[user.Server.casefold(user.Nick)] = user
 	delete(user.Server.Unregistered, &user.Conn)
 }
 
 func (user *ircUser) deleteUser() {
-	delete(user.Server.Unregistered, &user.Conn)
-	delete(user.Server.Clients, user.Nick)
+	server := user.Server
+	delete(server.Unregistered, &user.Conn)
+	if server.Nicks[server.casefold(user.Nick)] == user {
+		delete(server.Nicks, server.casefold(user.Nick))
+		delete(server.Clients, server.casefold(user.Nick))
+	}
+}
+
+func (user *ircUser) quit(reason string) {
+	// Removes the user from the server. Their connection is closed once
+	// everything already queued for them has been written. Safe to call twice.
+	if user.State == stateDisconnected {
+		return
+	}
+	if user.isRegistered() {
+		user.sendToPeers(false, "QUIT", reason)
+		user.Server.recordWhowas(user)
+		user.Server.notifyMonitors(user, false)
+	}
+	user.clearMonitors()
+	user.List = nil
+	atomic.StoreInt64(&user.drainBelow, 0)
+	for channel := range user.Channels {
+		channel.removeMember(user)
+	}
+	user.deleteUser()
+	user.write("ERROR :Closing link: " + user.getHostAddr() + " (" + reason + ")")
+	user.Conn.SetWriteDeadline(time.Now().Add(quitWriteTimeout))
+	user.State = stateDisconnected
+	close(user.Writer)
+	fmt.Printf("We've dropped connection to: %s (%s)\n", user.Nick, reason)
+}
+
+func (user *ircUser) write(line string) {
+	// Every outgoing line goes through here.
+	if user.State == stateDisconnected {
+		return
+	}
+	user.Writer <- line
+}
+
+func (user *ircUser) sendMessage(source string, command string, params ...string) {
+	msg := ircMessage{Source: source, Command: command, Params: params}
+	user.write(msg.String())
 }
 
 func (user *ircUser) Command(command string, line string) {
-	out := fmt.Sprintf(":%s %s %s :%s", user.Nick, command, user.Nick, line)
-	user.Writer <- out
+	user.sendMessage(user.Nick, command, user.Nick, line)
 }
 
 func (user *ircUser) serverWrite(variable string, command string, line string) {
-	out := fmt.Sprintf(":%s %s %s :%s", user.Server.Host, command, variable, line)
-	user.Writer <- out
+	user.sendMessage(user.Server.Host, command, variable, line)
 }
 
 func (user *ircUser) sendNumeric(numeric string, args ...string) {
 	out := fmt.Sprintf(":%s %s %s %s", user.Server.Host, numeric, user.Nick, strings.Join(args, " "))
-	user.Writer <- out
+	user.write(out)
 }
 
 func (user *ircUser) raw(line ...string) {
-	out := fmt.Sprintf(strings.Join(line, " "))
-	user.Writer <- out
+	user.write(strings.Join(line, " "))
 }
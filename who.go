@@ -0,0 +1,170 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WHOX fields, in the order they're always sent.
+const whoxFields = "tcuihsnfdlaor"
+
+func IRC_WHO(msg *ircMessage) (string, string) {
+	// WHO <mask> [o][%<fields>[,<token>]]
+	// The mask is a channel, or matched against nick, user, host, realname and server.
+	// o - operators only; fields - a WHOX reply with just those fields.
+	mask := msg.Params[0]
+	opersOnly, isWhox, fields, token := false, false, "", "0"
+	if len(msg.Params) > 1 {
+		var flags, whox string
+		flags, whox, isWhox = strings.Cut(msg.Params[1], "%")
+		opersOnly = strings.Contains(flags, "o")
+		fields, whox, _ = strings.Cut(whox, ",")
+		if whox != "" {
+			token = whox[:min(len(whox), 3)]
+		}
+		// No fields we know would be an empty 354, so that's a plain WHO.
+		isWhox = isWhox && strings.ContainsAny(fields, whoxFields)
+	}
+
+	for _, reply := range msg.User.whoMatches(mask) {
+		if opersOnly && !reply.user.isOper() {
+			continue
+		}
+		if isWhox {
+			msg.User.sendWhox(reply, fields, token)
+		} else {
+			msg.User.sendWho(reply)
+		}
+	}
+	return RPL_ENDOFWHO, mask + " :End of WHO list"
+}
+
+type whoReply struct {
+	user    *ircUser
+	channel *Channel // nil if they share no channel the asker can see.
+}
+
+func (user *ircUser) whoMatches(mask string) (replies []whoReply) {
+	// Invisible users only show up to people sharing a channel with them.
+	if strings.HasPrefix(mask, "#") {
+		channel, ok := user.Server.findChannel(mask)
+		if !ok || !channel.visibleTo(user) {
+			return nil
+		}
+		member := channel.isMember(user)
+		for target := range channel.Members {
+			if member || user.isOper() || !strings.Contains(target.Modes, "i") {
+				replies = append(replies, whoReply{target, channel})
+			}
+		}
+	} else {
+		everyone := mask == "0" || mask == "*"
+		for _, target := range user.Server.Clients {
+			if !everyone && !matchMask(mask, target.Nick) && !matchMask(mask, target.User) &&
+				!matchMask(mask, target.getHostAddr()) && !matchMask(mask, target.Realname) && !matchMask(mask, user.Server.Host) {
+				continue
+			}
+			channel := user.sharedChannel(target)
+			if channel == nil && target != user && !user.isOper() && strings.Contains(target.Modes, "i") {
+				continue
+			}
+			replies = append(replies, whoReply{target, channel})
+		}
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		return user.Server.casefold(replies[i].user.Nick) < user.Server.casefold(replies[j].user.Nick)
+	})
+	return
+}
+
+func (user *ircUser) sharedChannel(target *ircUser) *Channel {
+	// A channel of target's the asker may see, preferably one they're both in.
+	var visible *Channel
+	for channel := range target.Channels {
+		if channel.isMember(user) {
+			return channel
+		}
+		if visible == nil && !channel.isHidden() && !strings.Contains(target.Modes, "i") {
+			visible = channel
+		}
+	}
+	return visible
+}
+
+func (user *ircUser) whoFlags(reply whoReply) string {
+	// H(ere) or G(one), * for operators, then channel prefixes.
+	flags := "H"
+	if reply.user.AWAY {
+		flags = "G"
+	}
+	if reply.user.isOper() {
+		flags += "*"
+	}
+	if reply.channel != nil {
+		flags += reply.channel.Members[reply.user].prefixes(user.hasCap("multi-prefix"))
+	}
+	return flags
+}
+
+func (user *ircUser) sendWho(reply whoReply) {
+	target, channel := reply.user, "*"
+	if reply.channel != nil {
+		channel = reply.channel.Name
+	}
+	user.sendNumeric(RPL_WHOREPLY, channel, "~"+target.User, target.getHostAddr(), user.Server.Host, target.Nick,
+		user.whoFlags(reply), ":0 "+target.Realname)
+}
+
+func (user *ircUser) sendWhox(reply whoReply, fields string, token string) {
+	target := reply.user
+	var params []string
+	for i := range len(whoxFields) {
+		field := whoxFields[i]
+		if !strings.Contains(fields, string(field)) {
+			continue
+		}
+		switch field {
+		case 't':
+			params = append(params, token)
+		case 'c':
+			if reply.channel != nil {
+				params = append(params, reply.channel.Name)
+			} else {
+				params = append(params, "*")
+			}
+		case 'u':
+			params = append(params, "~"+target.User)
+		case 'i':
+			if user.isOper() || user == target {
+				params = append(params, target.getHostAddr())
+			} else {
+				params = append(params, "255.255.255.255")
+			}
+		case 'h':
+			params = append(params, target.getHostAddr())
+		case 's':
+			params = append(params, user.Server.Host)
+		case 'n':
+			params = append(params, target.Nick)
+		case 'f':
+			params = append(params, user.whoFlags(reply))
+		case 'd':
+			params = append(params, "0")
+		case 'l':
+			params = append(params, strconv.FormatInt(int64(time.Since(target.LastActive).Seconds()), 10))
+		case 'a':
+			if target.Account != "" {
+				params = append(params, target.Account)
+			} else {
+				params = append(params, "0")
+			}
+		case 'o':
+			params = append(params, "n/a")
+		case 'r':
+			params = append(params, ":"+target.Realname)
+		}
+	}
+	user.sendNumeric(RPL_WHOSPCRPL, params...)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Who(t *testing.T) {
+	server := mock_user().Server
+	alice, bob, carol := mock_client(server, "alice"), mock_client(server, "bob"), mock_client(server, "carol")
+	alice.Realname, alice.Account, bob.Realname, carol.Realname = "Alice A", "acct", "Bob B", "Carol C"
+	carol.Modes = "i"
+	bob.Modes = "o"
+	send(alice, "JOIN #test")
+	send(bob, "JOIN #test")
+	send(carol, "JOIN #test")
+	send(bob, "AWAY :gone")
+	drain(alice)
+
+	send(alice, "WHO #test")
+	lines := drain(alice)
+	want := []string{
+		":TestIRCd.testserver.net 352 alice #test ~alice 127.0.0.1 TestIRCd.testserver.net alice H~ :0 Alice A",
+		":TestIRCd.testserver.net 352 alice #test ~bob 127.0.0.1 TestIRCd.testserver.net bob G* :0 Bob B",
+		":TestIRCd.testserver.net 352 alice #test ~carol 127.0.0.1 TestIRCd.testserver.net carol H :0 Carol C",
+		":TestIRCd.testserver.net 315 alice #test :End of WHO list",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("WHO #test gave\n%s", strings.Join(lines, "\n"))
+	}
+
+	dave := mock_client(server, "dave")
+	send(dave, "WHO #test o")
+	send(dave, "WHO *")
+	lines = drain(dave)
+	if len(lines) != 6 || !strings.Contains(lines[0], " bob G* ") || !strings.Contains(lines[1], " 315 dave #test ") ||
+		!strings.Contains(lines[2], " 352 dave #test ~alice ") || !strings.Contains(lines[4], " 352 dave * ~dave ") {
+		t.Errorf("outsider got\n%s", strings.Join(lines, "\n"))
+	}
+
+	alice.Caps = map[string]bool{"multi-prefix": true}
+	send(alice, "WHO al* %tcnfar,42")
+	send(alice, "WHO alice %na")
+	lines = drain(alice)
+	if len(lines) != 4 || lines[0] != ":TestIRCd.testserver.net 354 alice 42 #test alice H~@ acct :Alice A" ||
+		lines[2] != ":TestIRCd.testserver.net 354 alice alice acct" {
+		t.Errorf("WHOX gave\n%s", strings.Join(lines, "\n"))
+	}
+
+	send(alice, "WHO alice %")
+	send(alice, "WHO alice %xyz,1")
+	lines = drain(alice)
+	if len(lines) != 4 || !strings.Contains(lines[0], " 352 alice #test ") || !strings.Contains(lines[2], " 352 alice #test ") {
+		t.Errorf("WHOX without fields gave\n%s", strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func IRC_WHOIS(msg *ircMessage) (string, string) {
+	// WHOIS [<server>] <nick>
+	// Every user is on this server, so the server parameter only moves the nick along.
+	nick := msg.Params[len(msg.Params)-1]
+	nick, _, _ = strings.Cut(nick, ",") // One nick at a time.
+	target, ok := msg.Server.findUser(nick)
+	if !ok {
+		msg.User.sendNumeric(ERR_NOSUCHNICK, nick, ":No such nick/channel")
+		return RPL_ENDOFWHOIS, nick + " :End of /WHOIS list"
+	}
+	msg.User.sendWhois(target)
+	return RPL_ENDOFWHOIS, target.Nick + " :End of /WHOIS list"
+}
+
+func (user *ircUser) sendWhois(target *ircUser) {
+	server := user.Server
+	// Some details are only for the user themselves and operators.
+	privileged := user == target || user.isOper()
+
+	user.sendNumeric(RPL_WHOISUSER, target.Nick, "~"+target.User, target.getHostAddr(), "*", ":"+target.Realname)
+	if channels := user.whoisChannels(target); len(channels) > 0 {
+		prefix := ":" + server.Host + " " + RPL_WHOISCHANNELS + " " + user.Nick + " " + target.Nick + " :"
+		for _, line := range packLines(prefix, " ", channels) {
+			user.sendNumeric(RPL_WHOISCHANNELS, target.Nick, ":"+line)
+		}
+	}
+	user.sendNumeric(RPL_WHOISSERVER, target.Nick, server.Host, ":"+server.Name)
+	if target.AWAY {
+		user.sendNumeric(RPL_AWAY, target.Nick, ":"+target.AwayMsg)
+	}
+	if target.isOper() {
+		user.sendNumeric(RPL_WHOISOPERATOR, target.Nick, ":is an IRC operator")
+	}
+	if target.Account != "" {
+		user.sendNumeric(RPL_WHOISACCOUNT, target.Nick, target.Account, ":is logged in as")
+	}
+	if target.Secure {
+		user.sendNumeric(RPL_WHOISSECURE, target.Nick, ":is using a secure connection")
+	}
+	if privileged {
+		if target.CertFP != "" {
+			user.sendNumeric(RPL_WHOISCERTFP, target.Nick, ":has client certificate fingerprint "+target.CertFP)
+		}
+		host := target.getHostAddr()
+		user.sendNumeric(RPL_WHOISHOST, target.Nick, ":is connecting from ~"+target.User+"@"+host+" "+host)
+	}
+	idle := int64(time.Since(target.LastActive).Seconds())
+	user.sendNumeric(RPL_WHOISIDLE, target.Nick, strconv.FormatInt(idle, 10), strconv.FormatInt(target.Signon.Unix(), 10),
+		":seconds idle, signon time")
+}
+
+func (user *ircUser) whoisChannels(target *ircUser) (channels []string) {
+	// Secret and private channels only show to other members of them, and an
+	// invisible user's channels only to people sharing them.
+	seeAll := user == target || user.isOper()
+	invisible := strings.Contains(target.Modes, "i")
+	var visible []*Channel
+	for channel := range target.Channels {
+		if seeAll || channel.isMember(user) || !(channel.isHidden() || invisible) {
+			visible = append(visible, channel)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Name < visible[j].Name })
+	for _, channel := range visible {
+		channels = append(channels, channel.Members[target].prefixes(user.hasCap("multi-prefix"))+channel.Name)
+	}
+	return
+}
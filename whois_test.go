@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Whois(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	alice.Realname, alice.Account, alice.Secure, alice.CertFP = "Alice A", "alice", true, "abcd"
+	alice.Signon, alice.LastActive = time.Unix(1000, 0), time.Now().Add(-time.Minute)
+	send(alice, "JOIN #public,#secret,#shared")
+	send(alice, "MODE #secret +s")
+	send(bob, "JOIN #shared")
+	send(alice, "AWAY :busy")
+	drain(alice)
+	drain(bob)
+
+	send(bob, "WHOIS ALICE")
+	lines := strings.Join(drain(bob), "\n")
+	for _, want := range []string{
+		" 311 bob alice ~alice 127.0.0.1 * :Alice A\n",
+		" 319 bob alice :~#public ~#shared\n",
+		" 312 bob alice TestIRCd.testserver.net :TestIRCd\n",
+		" 301 bob alice :busy\n",
+		" 330 bob alice alice :is logged in as\n",
+		" 671 bob alice :is using a secure connection\n",
+		" 317 bob alice 60 1000 :seconds idle, signon time\n",
+		" 318 bob alice :End of /WHOIS list",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("WHOIS is missing %q:\n%s", want, lines)
+		}
+	}
+	if strings.Contains(lines, " 378 ") || strings.Contains(lines, " 276 ") || strings.Contains(lines, " 313 ") {
+		t.Errorf("bob saw private details:\n%s", lines)
+	}
+
+	alice.Modes += "i"
+	send(bob, "WHOIS irc.example alice")
+	if lines := strings.Join(drain(bob), "\n"); !strings.Contains(lines, " 319 bob alice :~#shared\n") {
+		t.Errorf("invisible user's channels:\n%s", lines)
+	}
+
+	bob.Modes += "o"
+	send(bob, "WHOIS alice")
+	lines = strings.Join(drain(bob), "\n")
+	for _, want := range []string{
+		" 319 bob alice :~#public ~#secret ~#shared\n",
+		" 276 bob alice :has client certificate fingerprint abcd\n",
+		" 378 bob alice :is connecting from ~alice@127.0.0.1 127.0.0.1\n",
+	} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("opers' WHOIS is missing %q:\n%s", want, lines)
+		}
+	}
+
+	send(bob, "WHOIS nobody")
+	if lines := drain(bob); len(lines) != 2 || !strings.Contains(lines[0], " 401 bob nobody ") || !strings.HasSuffix(lines[1], " 318 bob nobody :End of /WHOIS list") {
+		t.Errorf("missing nick gave %q", lines)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	whowasPerNick  = 8    // Entries kept for each nick, older ones are dropped.
+	whowasMax      = 1000 // Entries kept across every nick, so nick cycling can't grow it forever.
+	nickListLength = 5    // Length of ircUser.NickList
+)
+
+type whowasEntry struct {
+	Nick     string
+	User     string
+	Host     string // Just the host, not nick!user@host
+	Realname string
+	Server   string
+	Time     time.Time // When they stopped using the nick.
+	Seq      uint64    // Counts up with every entry, so the server-wide limit finds it again.
+}
+
+type whowasRef struct {
+	Key string // casefold(nick)
+	Seq uint64
+}
+
+func (server *Server) recordWhowas(user *ircUser) {
+	key := server.casefold(user.Nick)
+	server.WhowasSeq++
+	entries := append(server.Whowas[key], whowasEntry{
+		user.Nick, user.User, user.getHostAddr(), user.Realname, server.Host, time.Now(), server.WhowasSeq,
+	})
+	if len(entries) > whowasPerNick {
+		entries = entries[len(entries)-whowasPerNick:]
+	}
+	server.Whowas[key] = entries
+
+	// The oldest entry server-wide goes, unless the per-nick limit got it first.
+	server.WhowasOrder = append(server.WhowasOrder, whowasRef{key, server.WhowasSeq})
+	for len(server.WhowasOrder) > whowasMax {
+		oldest := server.WhowasOrder[0]
+		server.WhowasOrder = server.WhowasOrder[1:]
+		if entries := server.Whowas[oldest.Key]; len(entries) > 0 && entries[0].Seq == oldest.Seq {
+			if len(entries) == 1 {
+				delete(server.Whowas, oldest.Key)
+			} else {
+				server.Whowas[oldest.Key] = entries[1:]
+			}
+		}
+	}
+}
+
+func IRC_WHOWAS(msg *ircMessage) (string, string) {
+	// WHOWAS <nick>{,<nick>} [<count> [<server>]]
+	// A count of 0 or less, or none, means every entry.
+	count := 0
+	if len(msg.Params) > 1 {
+		count, _ = strconv.Atoi(msg.Params[1])
+	}
+	for _, nick := range strings.Split(msg.Params[0], ",") {
+		entries := msg.Server.Whowas[msg.Server.casefold(nick)]
+		if len(entries) == 0 {
+			msg.User.sendNumeric(ERR_WASNOSUCHNICK, nick, ":There was no such nickname")
+			continue
+		}
+		for i, sent := len(entries)-1, 0; i >= 0 && (count <= 0 || sent < count); i, sent = i-1, sent+1 {
+			msg.User.sendWhowas(entries[i])
+		}
+	}
+	return RPL_ENDOFWHOWAS, msg.Params[0] + " :End of WHOWAS"
+}
+
+func (user *ircUser) sendWhowas(entry whowasEntry) {
+	user.sendNumeric(RPL_WHOWASUSER, entry.Nick, "~"+entry.User, entry.Host, "*", ":"+entry.Realname)
+	if user.isOper() {
+		user.sendNumeric(RPL_WHOWASIP, entry.Nick, ":was connecting from ~"+entry.User+"@"+entry.Host+" "+entry.Host)
+	}
+	user.sendNumeric(RPL_WHOISSERVER, entry.Nick, entry.Server, ":"+entry.Time.Format("Mon Jan 2 15:04:05 2006"))
+}
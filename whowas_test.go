@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Whowas(t *testing.T) {
+	server := mock_user().Server
+	alice, bob := mock_client(server, "alice"), mock_client(server, "bob")
+	alice.Realname = "Alice A"
+	for _, nick := range []string{"alice2", "alice3", "Alice"} {
+		send(alice, "NICK "+nick)
+	}
+	if strings.Join(alice.NickList, ",") != "alice3,alice2,alice" {
+		t.Errorf("NickList is %q", alice.NickList)
+	}
+	for i := range whowasPerNick + 2 {
+		send(alice, "NICK other")
+		if i%2 == 0 {
+			send(alice, "NICK alice")
+		} else {
+			send(alice, "NICK ALICE")
+		}
+	}
+	if len(alice.NickList) != nickListLength {
+		t.Errorf("NickList grew to %d", len(alice.NickList))
+	}
+	if n := len(server.Whowas["alice"]); n != whowasPerNick {
+		t.Errorf("kept %d entries for alice", n)
+	}
+	send(alice, "QUIT")
+	drain(bob)
+
+	send(bob, "WHOWAS Alice 2")
+	lines := drain(bob)
+	if len(lines) != 5 || lines[0] != ":TestIRCd.testserver.net 314 bob ALICE ~alice 127.0.0.1 * :Alice A" ||
+		!strings.Contains(lines[1], " 312 bob ALICE TestIRCd.testserver.net :") ||
+		!strings.Contains(lines[2], " 314 bob alice ") || !strings.HasSuffix(lines[4], " 369 bob Alice :End of WHOWAS") {
+		t.Errorf("WHOWAS gave %q", lines)
+	}
+
+	bob.Modes += "o"
+	send(bob, "WHOWAS nobody,alice3 0")
+	lines = drain(bob)
+	if len(lines) != 5 || !strings.Contains(lines[0], " 406 bob nobody ") ||
+		lines[2] != ":TestIRCd.testserver.net 379 bob alice3 :was connecting from ~alice@127.0.0.1 127.0.0.1" {
+		t.Errorf("WHOWAS for an oper gave %q", lines)
+	}
+}
+
+func Test_Whowas_Limit(t *testing.T) {
+	// Cycling through nicks doesn't keep every one of them.
+	server := mock_user().Server
+	carol := mock_client(server, "carol")
+	for i := range whowasMax + 10 {
+		send(carol, "NICK carol"+strconv.Itoa(i))
+		drain(carol)
+	}
+	total := 0
+	for _, entries := range server.Whowas {
+		total += len(entries)
+	}
+	if total > whowasMax || len(server.WhowasOrder) > whowasMax {
+		t.Errorf("kept %d WHOWAS entries", total)
+	}
+	if _, ok := server.Whowas["carol"]; ok {
+		t.Error("the oldest WHOWAS entry wasn't dropped")
+	}
+}
+
+func Test_Whowas_Limit_Stale(t *testing.T) {
+	// The per-nick limit already dropped dave's first entry, so the
+	// server-wide limit mustn't take another one of his in its place.
+	server := mock_user().Server
+	dave := mock_client(server, "dave")
+	for range whowasPerNick + 1 {
+		server.recordWhowas(dave)
+	}
+	same := time.Now()
+	for i := range server.Whowas["dave"] {
+		server.Whowas["dave"][i].Time = same
+	}
+	erin := mock_client(server, "erin")
+	for len(server.WhowasOrder) < whowasMax {
+		server.recordWhowas(erin)
+	}
+	server.recordWhowas(erin)
+	if n := len(server.Whowas["dave"]); n != whowasPerNick {
+		t.Errorf("dave has %d WHOWAS entries, want %d", n, whowasPerNick)
+	}
+}